@@ -50,7 +50,11 @@ func (ut *UnionType) String() string {
 	return "{" + strings.Join(s, " ") + "}"
 }
 
+// NewUnionType creates a new union type. Members that are union types
+// themselves are flattened into the new union type.
 func NewUnionType(types []Type) (*UnionType, error) {
+	types = flattenUnion(types)
+
 	sort.Slice(types, func(i, j int) bool {
 		return TypeCmp(types[i], types[j]) < 0
 	})
@@ -66,6 +70,20 @@ func NewUnionType(types []Type) (*UnionType, error) {
 	}, nil
 }
 
+func flattenUnion(types []Type) []Type {
+	flat := make([]Type, 0, len(types))
+
+	for _, typ := range types {
+		if ut, ok := typ.(*UnionType); ok {
+			flat = append(flat, flattenUnion(ut.Types)...)
+		} else {
+			flat = append(flat, typ)
+		}
+	}
+
+	return flat
+}
+
 type PrimType struct {
 	Type string
 }
@@ -78,6 +96,58 @@ func (pt *PrimType) String() string {
 	return pt.Type
 }
 
+// RecordType is a product type declared with `type`. Record types are
+// nominal: two record types are the same if they have the same name.
+type RecordType struct {
+	Name   string
+	Fields []Arg
+}
+
+func (*RecordType) IsType() bool {
+	return true
+}
+
+func (rt *RecordType) String() string {
+	return rt.Name
+}
+
+// Field returns the index of the field with the given name or -1 if the
+// record does not have such a field.
+func (rt *RecordType) Field(name string) int {
+	for i, field := range rt.Fields {
+		if field.Name == name {
+			return i
+		}
+	}
+
+	return -1
+}
+
+type ListType struct {
+	ElemType Type
+}
+
+func (*ListType) IsType() bool {
+	return true
+}
+
+func (lt *ListType) String() string {
+	return "list<" + lt.ElemType.String() + ">"
+}
+
+type MapType struct {
+	KeyType   Type
+	ValueType Type
+}
+
+func (*MapType) IsType() bool {
+	return true
+}
+
+func (mt *MapType) String() string {
+	return "map<" + mt.KeyType.String() + " " + mt.ValueType.String() + ">"
+}
+
 type ContractType struct {
 	Funcs map[string]*FuncType
 }
@@ -86,6 +156,30 @@ func (*ContractType) IsType() bool {
 	return true
 }
 
+func (ct *ContractType) String() string {
+	s := make([]string, 0)
+
+	for _, name := range ct.names() {
+		s = append(s, name+" "+ct.Funcs[name].String())
+	}
+
+	return "contract{" + strings.Join(s, " ") + "}"
+}
+
+// names returns the names of the functions of the contract in
+// sorted order.
+func (ct *ContractType) names() []string {
+	names := make([]string, 0, len(ct.Funcs))
+
+	for name := range ct.Funcs {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
 type FuncType struct {
 	ArgTypes []Type
 	RetTypes []Type
@@ -116,15 +210,53 @@ var InvalidType Type = nil
 type RootNode struct {
 	Funcs     []*FuncNode
 	TypeDecls map[string]*TypeDeclNode
+	Imports   []*ImportNode
+	Consts    []*ConstNode
 }
 
 func (*RootNode) IsNode() bool {
 	return true
 }
 
+// ImportNode is an import such as `import long.name as ln (f g)`. The
+// parser rewrites `ln:f` to `long.name:f` and the selected names `f`
+// and `g` to `long.name:f` and `long.name:g`.
+type ImportNode struct {
+	Module string
+	Alias  string
+	Names  []string
+	Token  *Token
+
+	// used and usedNames record which parts of the import were used
+	// by the file.
+	used      bool
+	usedNames map[string]bool
+}
+
+func (*ImportNode) IsNode() bool {
+	return true
+}
+
+// ConstNode is a constant such as `const pi 3.14`. Value is a literal.
+type ConstNode struct {
+	Name  string
+	Value Node
+	Pub   bool
+	Token *Token
+}
+
+func (*ConstNode) IsNode() bool {
+	return true
+}
+
 type TypeDeclNode struct {
 	Name string
 	Type Type
+
+	// Pub makes the functions of the record public. It does not
+	// restrict the use of the name of the type in other modules.
+	Pub   bool
+	Token *Token
 }
 
 func (*TypeDeclNode) IsNode() bool {
@@ -136,7 +268,11 @@ type FuncNode struct {
 	Args     []Arg
 	Body     []Node
 	RetTypes []Type
+	Pub      bool
 	Token    *Token
+
+	// Doc is the text of the comments directly preceding the function.
+	Doc string
 }
 
 func (*FuncNode) IsNode() bool {
@@ -170,6 +306,45 @@ func (*LitFloatNode) IsNode() bool {
 	return true
 }
 
+type LitBoolNode struct {
+	Value bool
+	Token *Token
+}
+
+func (*LitBoolNode) IsNode() bool {
+	return true
+}
+
+type LitStringNode struct {
+	Value string
+	Token *Token
+}
+
+func (*LitStringNode) IsNode() bool {
+	return true
+}
+
+type LitListNode struct {
+	Type  *ListType
+	Elems []Node
+	Token *Token
+}
+
+func (*LitListNode) IsNode() bool {
+	return true
+}
+
+type LitMapNode struct {
+	Type   *MapType
+	Keys   []Node
+	Values []Node
+	Token  *Token
+}
+
+func (*LitMapNode) IsNode() bool {
+	return true
+}
+
 type ReadVarNode struct {
 	Name  string
 	Token *Token
@@ -186,6 +361,28 @@ type IfElseNode struct {
 	Token     *Token
 }
 
+func (*IfElseNode) IsNode() bool {
+	return true
+}
+
+// MatchNode branches on the runtime type of the value on top of the
+// stack. Within the body of a case the value is narrowed to the type
+// of the case.
+type MatchNode struct {
+	Cases []*MatchCase
+	Token *Token
+}
+
+func (*MatchNode) IsNode() bool {
+	return true
+}
+
+type MatchCase struct {
+	Type  Type
+	Body  []Node
+	Token *Token
+}
+
 type VerbNode struct {
 	Verb  string
 	Token *Token
@@ -227,57 +424,154 @@ func TypeCmp(t1 Type, t2 Type) int {
 	// - void type
 	// - prim type
 	//   - sorted alphabetically
+	// - record type
+	//   - sorted alphabetically by name
+	// - list type
+	//   - by element type
+	// - map type
+	//   - by key type, then by value type
+	// - func type
+	//   - fewer argument types first, then by argument types
+	//   - fewer return types first, then by return types
+	// - contract type
+	//   - fewer functions first, then by names and types of functions
 	// - union type
+	//   - fewer types first, then by types
+
+	r1 := typeRank(t1)
+	r2 := typeRank(t2)
+
+	if r1 < r2 {
+		return -1
+	} else if r1 > r2 {
+		return 1
+	}
 
 	switch t1.(type) {
 	case *VoidType:
-		switch t2.(type) {
-		case *VoidType:
-			return 0
-		default:
-			return -1 // void type comes before any other type
-		}
+		return 0
 	case *PrimType:
-		switch t2.(type) {
-		case *PrimType:
-			return strings.Compare(t1.(*PrimType).Type, t2.(*PrimType).Type)
-		case *VoidType:
-			return 1 // PrimType comes after VoidType
-		case *UnionType:
-			return -1 // but it comes before UnionType
+		return strings.Compare(t1.(*PrimType).Type, t2.(*PrimType).Type)
+	case *RecordType:
+		return strings.Compare(t1.(*RecordType).Name, t2.(*RecordType).Name)
+	case *ListType:
+		return TypeCmp(t1.(*ListType).ElemType, t2.(*ListType).ElemType)
+	case *MapType:
+		mt1 := t1.(*MapType)
+		mt2 := t2.(*MapType)
+
+		c := TypeCmp(mt1.KeyType, mt2.KeyType)
+
+		if c != 0 {
+			return c
 		}
-	case *UnionType:
-		switch t2.(type) {
-		case *PrimType:
-			return 1 // UnionType comes after PrimType
-		case *VoidType:
-			return 1 // and after VoidType
-		case *UnionType:
-			// fewer types first / more types second
-			ut1 := t1.(*UnionType)
-			ut2 := t2.(*UnionType)
-
-			if len(ut1.Types) < len(ut1.Types) {
-				return -1
-			} else if len(ut1.Types) > len(ut2.Types) {
-				return 1
-			}
 
-			for i := 0; i < len(ut1.Types); i++ {
-				c := TypeCmp(ut1.Types[i], ut2.Types[i])
+		return TypeCmp(mt1.ValueType, mt2.ValueType)
+	case *FuncType:
+		ft1 := t1.(*FuncType)
+		ft2 := t2.(*FuncType)
 
-				if c != 0 {
-					return c
-				}
+		c := typesCmp(ft1.ArgTypes, ft2.ArgTypes)
+
+		if c != 0 {
+			return c
+		}
+
+		return typesCmp(ft1.RetTypes, ft2.RetTypes)
+	case *ContractType:
+		ct1 := t1.(*ContractType)
+		ct2 := t2.(*ContractType)
+
+		if len(ct1.Funcs) < len(ct2.Funcs) {
+			return -1
+		} else if len(ct1.Funcs) > len(ct2.Funcs) {
+			return 1
+		}
+
+		names1 := ct1.names()
+		names2 := ct2.names()
+
+		for i := 0; i < len(names1); i++ {
+			c := strings.Compare(names1[i], names2[i])
+
+			if c != 0 {
+				return c
 			}
 
-			return 0
+			c = TypeCmp(ct1.Funcs[names1[i]], ct2.Funcs[names2[i]])
+
+			if c != 0 {
+				return c
+			}
 		}
+
+		return 0
+	case *UnionType:
+		return typesCmp(t1.(*UnionType).Types, t2.(*UnionType).Types)
 	}
 
 	panic("BUG: can't compare these types?")
 }
 
+// typeRank returns the position of the kind of a type in the order
+// of types used by TypeCmp.
+func typeRank(t Type) int {
+	switch t.(type) {
+	case *VoidType:
+		return 0
+	case *PrimType:
+		return 1
+	case *RecordType:
+		return 2
+	case *ListType:
+		return 3
+	case *MapType:
+		return 4
+	case *FuncType:
+		return 5
+	case *ContractType:
+		return 6
+	case *UnionType:
+		return 7
+	}
+
+	panic("BUG: unknown type?")
+}
+
+// typesCmp compares two lists of types. Shorter lists come first and
+// lists of the same length are compared element wise.
+func typesCmp(ts1 []Type, ts2 []Type) int {
+	if len(ts1) < len(ts2) {
+		return -1
+	} else if len(ts1) > len(ts2) {
+		return 1
+	}
+
+	for i := 0; i < len(ts1); i++ {
+		c := TypeCmp(ts1[i], ts2[i])
+
+		if c != 0 {
+			return c
+		}
+	}
+
+	return 0
+}
+
+func nodesEqual(ns1 []Node, ns2 []Node) bool {
+	if len(ns1) != len(ns2) {
+		return false
+	}
+
+	for i := 0; i < len(ns1); i++ {
+		if !ASTEqual(ns1[i], ns2[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
 func ArgEqual(a1 Arg, a2 Arg) bool {
 	return a1.Name == a2.Name && TypeEqual(a1.Type, a2.Type)
 }
@@ -290,7 +584,7 @@ func ASTEqual(n1 Node, n2 Node) bool {
 			fn1 := n1.(*FuncNode)
 			fn2 := n2.(*FuncNode)
 
-			if fn1.Name != fn2.Name {
+			if fn1.Name != fn2.Name || fn1.Pub != fn2.Pub {
 				return false
 			}
 
@@ -342,6 +636,81 @@ func ASTEqual(n1 Node, n2 Node) bool {
 		default:
 			return false
 		}
+	case *LitBoolNode:
+		switch n2.(type) {
+		case *LitBoolNode:
+			return n1.(*LitBoolNode).Value == n2.(*LitBoolNode).Value
+		default:
+			return false
+		}
+	case *LitStringNode:
+		switch n2.(type) {
+		case *LitStringNode:
+			return n1.(*LitStringNode).Value == n2.(*LitStringNode).Value
+		default:
+			return false
+		}
+	case *LitListNode:
+		switch n2.(type) {
+		case *LitListNode:
+			n1_ := n1.(*LitListNode)
+			n2_ := n2.(*LitListNode)
+
+			return TypeEqual(n1_.Type, n2_.Type) && nodesEqual(n1_.Elems, n2_.Elems)
+		default:
+			return false
+		}
+	case *LitMapNode:
+		switch n2.(type) {
+		case *LitMapNode:
+			n1_ := n1.(*LitMapNode)
+			n2_ := n2.(*LitMapNode)
+
+			return TypeEqual(n1_.Type, n2_.Type) &&
+				nodesEqual(n1_.Keys, n2_.Keys) &&
+				nodesEqual(n1_.Values, n2_.Values)
+		default:
+			return false
+		}
+	case *IfElseNode:
+		switch n2.(type) {
+		case *IfElseNode:
+			n1_ := n1.(*IfElseNode)
+			n2_ := n2.(*IfElseNode)
+
+			if !ASTEqual(n1_.Condition, n2_.Condition) {
+				return false
+			}
+
+			return nodesEqual(n1_.ThenBlock, n2_.ThenBlock) &&
+				nodesEqual(n1_.ElseBlock, n2_.ElseBlock)
+		default:
+			return false
+		}
+	case *MatchNode:
+		switch n2.(type) {
+		case *MatchNode:
+			n1_ := n1.(*MatchNode)
+			n2_ := n2.(*MatchNode)
+
+			if len(n1_.Cases) != len(n2_.Cases) {
+				return false
+			}
+
+			for i := 0; i < len(n1_.Cases); i++ {
+				if !TypeEqual(n1_.Cases[i].Type, n2_.Cases[i].Type) {
+					return false
+				}
+
+				if !nodesEqual(n1_.Cases[i].Body, n2_.Cases[i].Body) {
+					return false
+				}
+			}
+
+			return true
+		default:
+			return false
+		}
 	case *VerbNode:
 		switch n2.(type) {
 		case *VerbNode:
@@ -0,0 +1,86 @@
+package gocat
+
+import (
+	"testing"
+)
+
+func TestTypeCmp(t *testing.T) {
+	ut, _ := NewUnionType([]Type{&PrimType{Type: "int"}, &PrimType{Type: "float"}})
+	ut2, _ := NewUnionType([]Type{&PrimType{Type: "int"}, &PrimType{Type: "float"}, &PrimType{Type: "bool"}})
+
+	// Types in ascending order.
+	types := []Type{
+		&VoidType{},
+		&PrimType{Type: "float"},
+		&PrimType{Type: "int"},
+		&RecordType{Name: "a:point"},
+		&RecordType{Name: "b:point"},
+		&ListType{ElemType: &PrimType{Type: "int"}},
+		&ListType{ElemType: &ListType{ElemType: &PrimType{Type: "int"}}},
+		&MapType{KeyType: &PrimType{Type: "float"}, ValueType: &PrimType{Type: "int"}},
+		&MapType{KeyType: &PrimType{Type: "int"}, ValueType: &PrimType{Type: "float"}},
+		&MapType{KeyType: &PrimType{Type: "int"}, ValueType: &PrimType{Type: "int"}},
+		&FuncType{
+			ArgTypes: []Type{},
+			RetTypes: []Type{&PrimType{Type: "int"}},
+		},
+		&FuncType{
+			ArgTypes: []Type{&PrimType{Type: "float"}},
+			RetTypes: []Type{},
+		},
+		&FuncType{
+			ArgTypes: []Type{&PrimType{Type: "int"}},
+			RetTypes: []Type{},
+		},
+		&ContractType{
+			Funcs: map[string]*FuncType{
+				"foo": &FuncType{},
+			},
+		},
+		&ContractType{
+			Funcs: map[string]*FuncType{
+				"bar": &FuncType{},
+				"foo": &FuncType{},
+			},
+		},
+		ut,
+		ut2,
+	}
+
+	for i := 0; i < len(types); i++ {
+		for j := 0; j < len(types); j++ {
+			c := TypeCmp(types[i], types[j])
+
+			if i < j && c >= 0 {
+				t.Fatalf("Expected %s < %s.", types[i], types[j])
+			}
+
+			if i == j && c != 0 {
+				t.Fatalf("Expected %s == %s.", types[i], types[j])
+			}
+
+			if i > j && c <= 0 {
+				t.Fatalf("Expected %s > %s.", types[i], types[j])
+			}
+		}
+	}
+}
+
+func TestNewUnionType(t *testing.T) {
+	inner, _ := NewUnionType([]Type{&PrimType{Type: "string"}, &PrimType{Type: "float"}})
+	ut, err := NewUnionType([]Type{&PrimType{Type: "int"}, inner})
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	if ut.String() != "{float int string}" {
+		t.Fatalf("Expected {float int string} but got %s.", ut)
+	}
+
+	_, err = NewUnionType([]Type{&PrimType{Type: "float"}, inner})
+
+	if err == nil {
+		t.Fatalf("Expected error but got none.")
+	}
+}
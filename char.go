@@ -1,7 +1,7 @@
 package gocat
 
 func isident(rn rune) bool {
-	return isletter(rn) || rn == '.' || rn == ':'
+	return isletter(rn) || rn == '.' || rn == ':' || rn == '_'
 }
 
 func isdigit(rn rune) bool {
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/FMNSSun/gocat"
+)
+
+func runCheck(args []string) int {
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	watch := flags.Bool("watch", false, "check again whenever a file changes")
+	interval := flags.Duration("interval", 500*time.Millisecond, "how often to poll for changes in watch mode")
+
+	if flags.Parse(args) != nil {
+		return 2
+	}
+
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "No module directories given.")
+		return 2
+	}
+
+	w, err := newWorkspace(flags.Args())
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 2
+	}
+
+	if !*watch {
+		if !check(w, os.Stdout) {
+			return 1
+		}
+
+		return 0
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	watchWorkspace(ctx, w, *interval, os.Stdout)
+	return 0
+}
+
+// workspace is a gocat.Workspace together with the directories of its
+// modules on the real file system.
+type workspace struct {
+	*gocat.Workspace
+
+	fsys fs.FS
+	dirs []string
+}
+
+// newWorkspace creates a workspace for the module directories dirs. The
+// workspace is rooted at the closest common ancestor of the directories.
+func newWorkspace(dirs []string) (*workspace, error) {
+	root := ""
+	abs := make([]string, len(dirs))
+
+	for i, dir := range dirs {
+		a, err := filepath.Abs(dir)
+
+		if err != nil {
+			return nil, err
+		}
+
+		abs[i] = a
+
+		if root == "" {
+			root = filepath.Dir(a)
+		}
+
+		for !isWithin(root, a) {
+			root = filepath.Dir(root)
+		}
+	}
+
+	fsys := os.DirFS(root)
+
+	ws := &workspace{
+		Workspace: gocat.NewWorkspace(fsys),
+		fsys:      fsys,
+	}
+
+	for _, a := range abs {
+		rel, err := filepath.Rel(root, a)
+
+		if err != nil {
+			return nil, err
+		}
+
+		dir := filepath.ToSlash(rel)
+
+		err = ws.AddModule(dir)
+
+		if err != nil {
+			return nil, err
+		}
+
+		ws.dirs = append(ws.dirs, dir)
+	}
+
+	return ws, nil
+}
+
+func isWithin(root string, path string) bool {
+	rel, err := filepath.Rel(root, path)
+
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// check checks the workspace and prints a summary to out. It returns
+// false if the check failed.
+func check(w *workspace, out io.Writer) bool {
+	start := time.Now()
+	modules, err := w.Check()
+	took := time.Since(start).Round(time.Millisecond)
+
+	if err != nil {
+		fmt.Fprintf(out, "FAIL (%s)\n%s\n", took, err.Error())
+		return false
+	}
+
+	funcs := 0
+
+	for _, module := range modules {
+		funcs += len(module.Funcs)
+	}
+
+	fmt.Fprintf(out, "ok: %d modules, %d functions, %d checked (%s)\n",
+		len(modules), funcs, len(w.Rechecked()), took)
+
+	names := make([]string, 0, len(modules))
+
+	for name := range modules {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, warning := range modules[name].Warnings {
+			fmt.Fprintln(out, warning.String())
+		}
+	}
+
+	return true
+}
+
+// fileStamp identifies a version of a file.
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+// snapshot returns the stamps of the gocat files and manifests of the
+// workspace.
+func snapshot(w *workspace) map[string]fileStamp {
+	stamps := make(map[string]fileStamp)
+
+	for _, dir := range w.dirs {
+		matches, _ := fs.Glob(w.fsys, dir+"/*.gct")
+		matches = append(matches, dir+"/"+gocat.ManifestFile)
+
+		for _, match := range matches {
+			fi, err := fs.Stat(w.fsys, match)
+
+			if err != nil {
+				continue
+			}
+
+			stamps[match] = fileStamp{
+				size:    fi.Size(),
+				modTime: fi.ModTime(),
+			}
+		}
+	}
+
+	return stamps
+}
+
+func sameSnapshot(a map[string]fileStamp, b map[string]fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for path, stamp := range a {
+		other, ok := b[path]
+
+		if !ok || other.size != stamp.size || !other.modTime.Equal(stamp.modTime) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// watchWorkspace checks the workspace and polls its files every interval
+// checking it again when they change until ctx is done.
+func watchWorkspace(ctx context.Context, w *workspace, interval time.Duration, out io.Writer) {
+	last := snapshot(w)
+
+	fmt.Fprintf(out, "[%s] ", time.Now().Format("15:04:05"))
+	check(w, out)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		current := snapshot(w)
+
+		if sameSnapshot(last, current) {
+			continue
+		}
+
+		last = current
+
+		fmt.Fprintf(out, "[%s] ", time.Now().Format("15:04:05"))
+		check(w, out)
+	}
+}
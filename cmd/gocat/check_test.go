@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFile(path string, code string, t *testing.T) {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+
+	if err == nil {
+		err = os.WriteFile(path, []byte(code), 0644)
+	}
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+}
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+
+	writeFile(filepath.Join(dir, "a", "geo", "geo.gct"), "pub func two [] [int] { 2; }\n", t)
+	writeFile(filepath.Join(dir, "b", "app", "app.gct"), "func four [] [int] { geo:two geo:two div.i; }\n", t)
+
+	w, err := newWorkspace([]string{filepath.Join(dir, "a", "geo"), filepath.Join(dir, "b", "app")})
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	var out bytes.Buffer
+
+	if !check(w, &out) || !strings.HasPrefix(out.String(), "ok: 2 modules, 2 functions, 2 checked") {
+		t.Fatalf("Unexpected output: %q", out.String())
+	}
+
+	before := snapshot(w)
+
+	if !sameSnapshot(before, snapshot(w)) {
+		t.Fatalf("Snapshots of unchanged files differ.")
+	}
+
+	writeFile(filepath.Join(dir, "a", "geo", "geo.gct"), "pub func two [] [float] { 2.0; }\n", t)
+
+	if sameSnapshot(before, snapshot(w)) {
+		t.Fatalf("Snapshots of changed files are the same.")
+	}
+
+	out.Reset()
+
+	if check(w, &out) || !strings.HasPrefix(out.String(), "FAIL") {
+		t.Fatalf("Unexpected output: %q", out.String())
+	}
+
+	// Manifests are watched too.
+	before = snapshot(w)
+
+	writeFile(filepath.Join(dir, "a", "geo", "gocat.mod"), "module geo\n", t)
+
+	if sameSnapshot(before, snapshot(w)) {
+		t.Fatalf("Snapshots with a new manifest are the same.")
+	}
+
+	before = snapshot(w)
+
+	writeFile(filepath.Join(dir, "a", "geo", "gocat.mod"), "module geo\nversion 1.0.0\n", t)
+
+	if sameSnapshot(before, snapshot(w)) {
+		t.Fatalf("Snapshots of changed manifests are the same.")
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/FMNSSun/gocat"
+)
+
+// sourceBlocks returns the blocks of the module that are not part of
+// test files.
+func sourceBlocks(cover *gocat.Coverage, module *gocat.Module) []*gocat.CoverBlock {
+	var blocks []*gocat.CoverBlock
+
+	for _, block := range cover.Blocks(module) {
+		if !strings.HasSuffix(block.Pos.FilePath, gocat.TestFileSuffix) {
+			blocks = append(blocks, block)
+		}
+	}
+
+	return blocks
+}
+
+// coverPercent returns the percentage of executed blocks.
+func coverPercent(blocks []*gocat.CoverBlock) float64 {
+	if len(blocks) == 0 {
+		return 100
+	}
+
+	covered := 0
+
+	for _, block := range blocks {
+		if block.Count > 0 {
+			covered++
+		}
+	}
+
+	return 100 * float64(covered) / float64(len(blocks))
+}
+
+// writeCoverFuncs writes the coverage of the functions of the modules
+// names like `go tool cover -func`.
+func writeCoverFuncs(out io.Writer, modules map[string]*gocat.Module, names []string, cover *gocat.Coverage) error {
+	tw := tabwriter.NewWriter(out, 0, 8, 1, '\t', 0)
+
+	var all []*gocat.CoverBlock
+
+	for _, name := range names {
+		for _, fc := range cover.Funcs(modules[name]) {
+			if strings.HasSuffix(fc.Pos.FilePath, gocat.TestFileSuffix) {
+				continue
+			}
+
+			fmt.Fprintf(tw, "%s:%d:\t%s\t%.1f%%\n", fc.Pos.FilePath, fc.Pos.LineNumber, fc.Func, fc.Percent())
+		}
+
+		all = append(all, sourceBlocks(cover, modules[name])...)
+	}
+
+	fmt.Fprintf(tw, "total:\t(statements)\t%.1f%%\n", coverPercent(all))
+
+	return tw.Flush()
+}
+
+// coverFile is a source file annotated with coverage.
+type coverFile struct {
+	Path    string
+	Percent float64
+	Lines   []*coverLine
+}
+
+type coverLine struct {
+	Number int
+	Text   string
+
+	// Class is `cov` if all blocks starting on the line were executed,
+	// `uncov` if none were, `partial` if some were and empty if no
+	// blocks start on the line.
+	Class string
+	Count uint64
+}
+
+// coverFiles annotates the source files of the modules names.
+func coverFiles(modules map[string]*gocat.Module, names []string, cover *gocat.Coverage) ([]*coverFile, error) {
+	var files []*coverFile
+	byPath := make(map[string][]*gocat.CoverBlock)
+
+	for _, name := range names {
+		for _, block := range sourceBlocks(cover, modules[name]) {
+			fpath := block.Pos.FilePath
+
+			if byPath[fpath] == nil {
+				files = append(files, &coverFile{Path: fpath})
+			}
+
+			byPath[fpath] = append(byPath[fpath], block)
+		}
+	}
+
+	for _, file := range files {
+		src, err := os.ReadFile(file.Path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		blocks := byPath[file.Path]
+		file.Percent = coverPercent(blocks)
+
+		for i, text := range strings.Split(strings.TrimRight(string(src), "\n"), "\n") {
+			file.Lines = append(file.Lines, &coverLine{
+				Number: i + 1,
+				Text:   text,
+			})
+		}
+
+		covered := make(map[int]int)
+		total := make(map[int]int)
+
+		for _, block := range blocks {
+			n := int(block.Pos.LineNumber)
+
+			if n < 1 || n > len(file.Lines) {
+				continue
+			}
+
+			total[n]++
+
+			if block.Count > 0 {
+				covered[n]++
+			}
+
+			if block.Count > file.Lines[n-1].Count {
+				file.Lines[n-1].Count = block.Count
+			}
+		}
+
+		for n, t := range total {
+			switch covered[n] {
+			case 0:
+				file.Lines[n-1].Class = "uncov"
+			case t:
+				file.Lines[n-1].Class = "cov"
+			default:
+				file.Lines[n-1].Class = "partial"
+			}
+		}
+	}
+
+	return files, nil
+}
+
+var coverHTML = template.Must(template.New("cover").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>gocat coverage</title>
+<style>
+body { font-family: sans-serif; }
+pre { background: #000; color: #888; padding: 1em; }
+.cov { color: #2c2; }
+.partial { color: #cc2; }
+.uncov { color: #c22; }
+</style>
+</head>
+<body>
+{{- range .}}
+<h2><code>{{.Path}}</code> ({{printf "%.1f" .Percent}}%)</h2>
+<pre>
+{{- range .Lines}}
+{{if .Class}}<span class="{{.Class}}" title="{{.Count}}">{{printf "%4d" .Number}}  {{.Text}}</span>{{else}}{{printf "%4d" .Number}}  {{.Text}}{{end}}
+{{- end}}
+</pre>
+{{- end}}
+</body>
+</html>
+`))
+
+// writeCoverHTML writes the source files of the modules names annotated
+// with coverage as HTML.
+func writeCoverHTML(out io.Writer, modules map[string]*gocat.Module, names []string, cover *gocat.Coverage) error {
+	files, err := coverFiles(modules, names, cover)
+
+	if err != nil {
+		return err
+	}
+
+	return coverHTML.Execute(out, files)
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/FMNSSun/gocat"
+)
+
+// breakpointsFlag collects the breakpoints given by repeated flags.
+type breakpointsFlag []string
+
+func (bf *breakpointsFlag) String() string {
+	return strings.Join(*bf, ",")
+}
+
+func (bf *breakpointsFlag) Set(s string) error {
+	_, _, err := parseBreakpoint(s)
+
+	if err != nil {
+		return err
+	}
+
+	*bf = append(*bf, s)
+	return nil
+}
+
+func runDebug(args []string) int {
+	var breakpoints breakpointsFlag
+
+	flags := flag.NewFlagSet("debug", flag.ContinueOnError)
+	flags.Var(&breakpoints, "break", "set a breakpoint at `file:line` (may be repeated)")
+
+	if flags.Parse(args) != nil {
+		return 2
+	}
+
+	if flags.NArg() < 2 {
+		fmt.Fprintln(os.Stderr, "Expected a function and at least one module directory.")
+		return 2
+	}
+
+	fqname := flags.Arg(0)
+
+	modules, _, err := loadTestModules(flags.Args()[1:])
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	s := newDebugSession(os.Stdin, os.Stdout)
+
+	for _, bp := range breakpoints {
+		s.command("break " + bp)
+	}
+
+	if !s.run(modules, fqname) {
+		return 1
+	}
+
+	return 0
+}
+
+// parseBreakpoint parses a breakpoint of the form `file:line`.
+func parseBreakpoint(s string) (string, uint32, error) {
+	i := strings.LastIndex(s, ":")
+
+	if i <= 0 {
+		return "", 0, fmt.Errorf("Invalid breakpoint `%s`. Breakpoints look like `file.gct:12`.", s)
+	}
+
+	line, err := strconv.ParseUint(s[i+1:], 10, 32)
+
+	if err != nil || line == 0 {
+		return "", 0, fmt.Errorf("Invalid breakpoint `%s`. Breakpoints look like `file.gct:12`.", s)
+	}
+
+	return s[:i], uint32(line), nil
+}
+
+// debugSession is an interactive debugging session reading commands from
+// in and writing to out.
+type debugSession struct {
+	in  *bufio.Scanner
+	out io.Writer
+
+	debugger *gocat.Debugger
+	state    *gocat.DebugState
+
+	// sources caches the lines of source files.
+	sources map[string][]string
+
+	// last is the last command which is repeated by an empty line.
+	last string
+}
+
+func newDebugSession(in io.Reader, out io.Writer) *debugSession {
+	s := &debugSession{
+		in:      bufio.NewScanner(in),
+		out:     out,
+		sources: make(map[string][]string),
+	}
+
+	s.debugger = gocat.NewDebugger(s.pause)
+
+	return s
+}
+
+// run initialises the modules and debugs the function fqname. It returns
+// false if the function doesn't exist or fails.
+func (s *debugSession) run(modules map[string]*gocat.Module, fqname string) bool {
+	i := strings.LastIndex(fqname, ":")
+
+	if i < 0 || modules[fqname[:i]] == nil || modules[fqname[:i]].Funcs[fqname[i+1:]] == nil {
+		fmt.Fprintf(s.out, "Function `%s` does not exist!\n", fqname)
+		return false
+	}
+
+	fn := modules[fqname[:i]].Funcs[fqname[i+1:]]
+
+	if len(fn.Type.ArgTypes) != 0 {
+		fmt.Fprintf(s.out, "Function `%s` takes arguments. Only functions without arguments can be debugged.\n", fqname)
+		return false
+	}
+
+	rt := gocat.NewRuntime(modules)
+
+	err := rt.Init()
+
+	if err != nil {
+		fmt.Fprintln(s.out, err.Error())
+		return false
+	}
+
+	// Without breakpoints the execution pauses right away.
+	if len(s.debugger.Breakpoints()) == 0 {
+		s.debugger.Break()
+	}
+
+	rt.SetDebugger(s.debugger)
+
+	rets, err := rt.Exec(fqname, nil)
+
+	if err != nil {
+		fmt.Fprintln(s.out, err.Error())
+		return false
+	}
+
+	fmt.Fprintf(s.out, "%s returned %s\n", fqname, formatValues(rets))
+	return true
+}
+
+func formatValues(values []gocat.Value) string {
+	strs := make([]string, len(values))
+
+	for i, v := range values {
+		strs[i] = gocat.FormatValue(v)
+	}
+
+	return "[" + strings.Join(strs, " ") + "]"
+}
+
+// pause reads and executes commands until a command continues the
+// execution.
+func (s *debugSession) pause(state *gocat.DebugState) gocat.DebugAction {
+	s.state = state
+
+	fmt.Fprintf(s.out, "> %s %s:%d\n", state.Frames[0].Func, state.Pos.FilePath, state.Pos.LineNumber)
+	s.list(state.Pos, 0)
+
+	for {
+		fmt.Fprint(s.out, "(gocat) ")
+
+		if !s.in.Scan() {
+			fmt.Fprintln(s.out)
+			return gocat.DebugAbort
+		}
+
+		line := strings.TrimSpace(s.in.Text())
+
+		if line == "" {
+			line = s.last
+		}
+
+		s.last = line
+
+		action, ok := s.command(line)
+
+		if ok {
+			return action
+		}
+	}
+}
+
+var debugHelp = `Commands:
+  s, step              step into the next call
+  n, next              step over the next call
+  o, out               step out of the current function
+  c, continue          continue until the next breakpoint
+  b, break file:line   set a breakpoint
+  clear file:line      remove a breakpoint
+  breakpoints          list the breakpoints
+  stack                print the value stack of the current function
+  args                 print the arguments of the current function
+  bt                   print the call stack
+  l, list              print the source around the current line
+  q, quit              abort the execution
+An empty line repeats the last command.
+`
+
+// command executes a command. It returns true and the action to continue
+// with if the command continues the execution.
+func (s *debugSession) command(line string) (gocat.DebugAction, bool) {
+	fields := strings.Fields(line)
+
+	if len(fields) == 0 {
+		return gocat.DebugContinue, false
+	}
+
+	switch fields[0] {
+	case "s", "step":
+		return gocat.DebugStepInto, true
+	case "n", "next":
+		return gocat.DebugStepOver, true
+	case "o", "out":
+		return gocat.DebugStepOut, true
+	case "c", "continue":
+		return gocat.DebugContinue, true
+	case "q", "quit":
+		return gocat.DebugAbort, true
+	case "b", "break", "clear":
+		if len(fields) != 2 {
+			fmt.Fprintf(s.out, "Usage: %s file:line\n", fields[0])
+			break
+		}
+
+		fpath, n, err := parseBreakpoint(fields[1])
+
+		if err != nil {
+			fmt.Fprintln(s.out, err.Error())
+			break
+		}
+
+		if fields[0] != "clear" {
+			s.debugger.SetBreakpoint(fpath, n)
+		} else if !s.debugger.ClearBreakpoint(fpath, n) {
+			fmt.Fprintf(s.out, "No breakpoint at %s:%d.\n", fpath, n)
+		}
+	case "breakpoints":
+		for _, bp := range s.debugger.Breakpoints() {
+			fmt.Fprintf(s.out, "%s:%d\n", bp.FilePath, bp.Line)
+		}
+	case "stack":
+		if s.state == nil {
+			break
+		}
+
+		// The top of the stack is printed first.
+		for i := len(s.state.Stack) - 1; i >= 0; i-- {
+			fmt.Fprintf(s.out, "%d: %s\n", len(s.state.Stack)-1-i, gocat.FormatValue(s.state.Stack[i]))
+		}
+	case "args":
+		if s.state == nil {
+			break
+		}
+
+		names := make([]string, 0, len(s.state.Args))
+
+		for name := range s.state.Args {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		for _, name := range names {
+			fmt.Fprintf(s.out, "%s = %s\n", name, gocat.FormatValue(s.state.Args[name]))
+		}
+	case "bt":
+		if s.state == nil {
+			break
+		}
+
+		for _, fr := range s.state.Frames {
+			if fr.Pos == nil {
+				fmt.Fprintf(s.out, "%s\n\t<unknown>\n", fr.Func)
+			} else {
+				fmt.Fprintf(s.out, "%s\n\t%s:%d\n", fr.Func, fr.Pos.FilePath, fr.Pos.LineNumber)
+			}
+		}
+	case "l", "list":
+		if s.state != nil {
+			s.list(s.state.Pos, 3)
+		}
+	case "h", "help":
+		fmt.Fprint(s.out, debugHelp)
+	default:
+		fmt.Fprintf(s.out, "Unknown command `%s`. Type `help` for a list of commands.\n", fields[0])
+	}
+
+	return gocat.DebugContinue, false
+}
+
+// list prints the lines of the source around pos.
+func (s *debugSession) list(pos *gocat.FilePos, context int) {
+	lines, ok := s.sources[pos.FilePath]
+
+	if !ok {
+		src, err := os.ReadFile(pos.FilePath)
+
+		if err == nil {
+			lines = strings.Split(string(src), "\n")
+		}
+
+		s.sources[pos.FilePath] = lines
+	}
+
+	n := int(pos.LineNumber)
+
+	for i := n - context; i <= n+context; i++ {
+		if i < 1 || i > len(lines) {
+			continue
+		}
+
+		marker := " "
+
+		if i == n {
+			marker = "=>"
+		}
+
+		fmt.Fprintf(s.out, "%2s %4d  %s\n", marker, i, lines[i-1])
+	}
+}
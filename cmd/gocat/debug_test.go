@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDebug(t *testing.T) {
+	dir := t.TempDir()
+
+	writeFile(filepath.Join(dir, "geo", "geo.gct"), `func main [] [int] {
+	3 geo:double;
+}
+
+func double [(x int)] [int] {
+	x x
+	geo:add;
+}
+
+func add [(a int) (b int)] [int] {
+	a;
+}
+`, t)
+
+	modules, _, err := loadTestModules([]string{filepath.Join(dir, "geo")})
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	in := strings.Join([]string{
+		"step",
+		"",
+		"args",
+		"break geo.gct:7",
+		"c",
+		"stack",
+		"bt",
+		"bogus",
+		"clear geo.gct:7",
+		"c",
+	}, "\n")
+
+	var out bytes.Buffer
+
+	s := newDebugSession(strings.NewReader(in), &out)
+
+	if !s.run(modules, "geo:main") {
+		t.Fatalf("Unexpected failure:\n%s", out.String())
+	}
+
+	for _, exp := range []string{
+		"> geo:main " + filepath.Join(dir, "geo", "geo.gct") + ":2\n=>    2  \t3 geo:double;\n",
+		"> geo:double " + filepath.Join(dir, "geo", "geo.gct") + ":6\n",
+		"(gocat) x = 3\n",
+		"> geo:double " + filepath.Join(dir, "geo", "geo.gct") + ":7\n",
+		"(gocat) 0: 3\n1: 3\n",
+		"(gocat) geo:double\n\t" + filepath.Join(dir, "geo", "geo.gct") + ":7\ngeo:main\n\t" + filepath.Join(dir, "geo", "geo.gct") + ":2\n",
+		"Unknown command `bogus`.",
+		"geo:main returned [3]\n",
+	} {
+		if !strings.Contains(out.String(), exp) {
+			t.Fatalf("Expected %q in:\n%s", exp, out.String())
+		}
+	}
+
+	// The execution is aborted when the input ends.
+	out.Reset()
+
+	s = newDebugSession(strings.NewReader("n\n"), &out)
+
+	if s.run(modules, "geo:main") || !strings.Contains(out.String(), "Execution aborted by the debugger.") {
+		t.Fatalf("Unexpected output:\n%s", out.String())
+	}
+
+	out.Reset()
+
+	s = newDebugSession(strings.NewReader(""), &out)
+
+	if s.run(modules, "geo:double") || !strings.Contains(out.String(), "takes arguments") {
+		t.Fatalf("Unexpected output:\n%s", out.String())
+	}
+}
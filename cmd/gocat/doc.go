@@ -0,0 +1,197 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/FMNSSun/gocat"
+)
+
+func runDoc(args []string) int {
+	flags := flag.NewFlagSet("doc", flag.ContinueOnError)
+	format := flags.String("format", "markdown", "output format, `markdown` or `html`")
+	all := flags.Bool("all", false, "include private functions")
+
+	if flags.Parse(args) != nil {
+		return 2
+	}
+
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Expected exactly one module directory.")
+		return 2
+	}
+
+	if *format != "markdown" && *format != "html" {
+		fmt.Fprintf(os.Stderr, "Unknown format `%s`.\n", *format)
+		return 2
+	}
+
+	module, err := gocat.LoadModule(flags.Arg(0))
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	ref := newDocModule(module, *all)
+
+	if *format == "html" {
+		err = writeDocHTML(os.Stdout, ref)
+	} else {
+		err = writeDocMarkdown(os.Stdout, ref)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	return 0
+}
+
+// docModule is the reference documentation of a module.
+type docModule struct {
+	Name    string
+	Version string
+	Funcs   []*docFunc
+}
+
+type docFunc struct {
+	Name      string
+	Signature string
+	Args      []gocat.Arg
+	Pub       bool
+
+	// Paragraphs are the paragraphs of the doc comment.
+	Paragraphs []string
+}
+
+// newDocModule collects the documentation of the functions declared in
+// the module sorted by name. Private functions are only included if all
+// is true.
+func newDocModule(module *gocat.Module, all bool) *docModule {
+	ref := &docModule{
+		Name: module.Name,
+	}
+
+	if module.Manifest != nil && module.Manifest.Version != nil {
+		ref.Version = module.Manifest.Version.String()
+	}
+
+	names := make([]string, 0, len(module.Funcs))
+
+	for name := range module.Funcs {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		fn := module.Funcs[name]
+
+		if fn.FuncNode == nil || (!fn.Pub && !all) {
+			continue
+		}
+
+		ref.Funcs = append(ref.Funcs, &docFunc{
+			Name:       module.Name + ":" + name,
+			Signature:  fn.Type.String(),
+			Args:       fn.FuncNode.Args,
+			Pub:        fn.Pub,
+			Paragraphs: paragraphs(fn.FuncNode.Doc),
+		})
+	}
+
+	return ref
+}
+
+// paragraphs splits text into paragraphs separated by empty lines.
+func paragraphs(text string) []string {
+	var paras []string
+
+	for _, para := range strings.Split(text, "\n\n") {
+		para = strings.TrimSpace(para)
+
+		if para != "" {
+			paras = append(paras, para)
+		}
+	}
+
+	return paras
+}
+
+func writeDocMarkdown(out io.Writer, ref *docModule) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# Module `%s`\n", ref.Name)
+
+	if ref.Version != "" {
+		fmt.Fprintf(&b, "\nVersion %s\n", ref.Version)
+	}
+
+	for _, fn := range ref.Funcs {
+		fmt.Fprintf(&b, "\n## `%s`\n\n", fn.Name)
+		fmt.Fprintf(&b, "```\n%s\n```\n", fn.Signature)
+
+		if !fn.Pub {
+			fmt.Fprintf(&b, "\nPrivate to the module.\n")
+		}
+
+		if len(fn.Args) > 0 {
+			fmt.Fprintf(&b, "\nArguments:\n\n")
+
+			for _, arg := range fn.Args {
+				fmt.Fprintf(&b, "- `%s` `%s`\n", arg.Name, arg.Type)
+			}
+		}
+
+		for _, para := range fn.Paragraphs {
+			fmt.Fprintf(&b, "\n%s\n", para)
+		}
+	}
+
+	_, err := io.WriteString(out, b.String())
+	return err
+}
+
+var docHTML = template.Must(template.New("doc").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Module {{.Name}}</title>
+</head>
+<body>
+<h1>Module <code>{{.Name}}</code></h1>
+{{- if .Version}}
+<p>Version {{.Version}}</p>
+{{- end}}
+{{- range .Funcs}}
+<h2 id="{{.Name}}"><code>{{.Name}}</code></h2>
+<pre>{{.Signature}}</pre>
+{{- if not .Pub}}
+<p>Private to the module.</p>
+{{- end}}
+{{- if .Args}}
+<p>Arguments:</p>
+<ul>
+{{- range .Args}}
+<li><code>{{.Name}}</code> <code>{{.Type}}</code></li>
+{{- end}}
+</ul>
+{{- end}}
+{{- range .Paragraphs}}
+<p>{{.}}</p>
+{{- end}}
+{{- end}}
+</body>
+</html>
+`))
+
+func writeDocHTML(out io.Writer, ref *docModule) error {
+	return docHTML.Execute(out, ref)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/FMNSSun/gocat"
+)
+
+const docTestCode = `
+// origin returns the origin.
+pub func origin [] [int int] {
+	0 0;
+}
+
+// scale scales a point.
+//
+// Both coordinates are multiplied by <factor>.
+pub func scale [(x int) (y int) (factor int)] [int int] {
+	x factor mul.i y factor mul.i;
+}
+
+func helper [] [] {
+}
+`
+
+func TestDoc(t *testing.T) {
+	dir := t.TempDir()
+
+	writeFile(filepath.Join(dir, "geo", "geo.gct"), docTestCode, t)
+	writeFile(filepath.Join(dir, "geo", gocat.ManifestFile), "module geo\nversion 1.2.0\n", t)
+
+	module, err := gocat.LoadModule(filepath.Join(dir, "geo"))
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	var out bytes.Buffer
+
+	err = writeDocMarkdown(&out, newDocModule(module, false))
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	md := out.String()
+
+	for _, exp := range []string{
+		"# Module `geo`\n\nVersion 1.2.0\n",
+		"## `geo:origin`\n\n```\nfunc{ : int int}\n```\n\norigin returns the origin.\n",
+		"```\nfunc{int int int : int int}\n```\n",
+		"- `factor` `int`\n",
+		"\nBoth coordinates are multiplied by <factor>.\n",
+	} {
+		if !strings.Contains(md, exp) {
+			t.Fatalf("Expected %q in:\n%s", exp, md)
+		}
+	}
+
+	if strings.Contains(md, "helper") {
+		t.Fatalf("Unexpected private function in:\n%s", md)
+	}
+
+	out.Reset()
+
+	err = writeDocHTML(&out, newDocModule(module, true))
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	html := out.String()
+
+	for _, exp := range []string{
+		"<h2 id=\"geo:helper\"><code>geo:helper</code></h2>",
+		"<p>Private to the module.</p>",
+		"<li><code>factor</code> <code>int</code></li>",
+		"<p>Both coordinates are multiplied by &lt;factor&gt;.</p>",
+	} {
+		if !strings.Contains(html, exp) {
+			t.Fatalf("Expected %q in:\n%s", exp, html)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+// Command gocat checks, documents, tests and debugs gocat modules.
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+type command struct {
+	usage string
+	run   func(args []string) int
+}
+
+var commands = map[string]*command{
+	"check": {
+		usage: "check [--watch] [--interval duration] <dirs>",
+		run:   runCheck,
+	},
+	"debug": {
+		usage: "debug [--break file:line] <module:func> <dirs>",
+		run:   runDebug,
+	},
+	"doc": {
+		usage: "doc [--format markdown|html] [--all] <dir>",
+		run:   runDoc,
+	},
+	"test": {
+		usage: "test [-v] [-run regexp] [--cover] [--coverhtml file] [--profile file] <dirs>",
+		run:   runTest,
+	},
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: gocat <command> [arguments]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+
+	names := make([]string, 0, len(commands))
+
+	for name := range commands {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "\tgocat %s\n", commands[name].usage)
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd := commands[os.Args[1]]
+
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "Unknown command `%s`.\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+
+	os.Exit(cmd.run(os.Args[2:]))
+}
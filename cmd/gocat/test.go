@@ -0,0 +1,266 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/FMNSSun/gocat"
+)
+
+func runTest(args []string) int {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	verbose := flags.Bool("v", false, "print the names of all tests")
+	run := flags.String("run", "", "only run tests matching the regular expression")
+	cover := flags.Bool("cover", false, "report the coverage of the functions")
+	coverHTML := flags.String("coverhtml", "", "write the source annotated with coverage as HTML to `file`")
+	profile := flags.String("profile", "", "write a pprof profile of the tests to `file`")
+
+	if flags.Parse(args) != nil {
+		return 2
+	}
+
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "No module directories given.")
+		return 2
+	}
+
+	filter, err := regexp.Compile(*run)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 2
+	}
+
+	modules, names, err := loadTestModules(flags.Args())
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	t := &tester{
+		out:     os.Stdout,
+		verbose: *verbose,
+		filter:  filter,
+	}
+
+	if *cover || *coverHTML != "" {
+		t.cover = gocat.NewCoverage()
+	}
+
+	if *profile != "" {
+		t.prof = gocat.NewProfiler()
+	}
+
+	ok := t.run(modules, names)
+
+	if *cover {
+		err = writeCoverFuncs(os.Stdout, modules, names, t.cover)
+
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 1
+		}
+	}
+
+	if *coverHTML != "" {
+		err = writeCoverHTMLFile(*coverHTML, modules, names, t.cover)
+
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 1
+		}
+	}
+
+	if *profile != "" {
+		err = writeProfileFile(*profile, t.prof)
+
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 1
+		}
+	}
+
+	if !ok {
+		return 1
+	}
+
+	return 0
+}
+
+func writeProfileFile(fpath string, prof *gocat.Profiler) error {
+	f, err := os.Create(fpath)
+
+	if err != nil {
+		return err
+	}
+
+	err = prof.WriteProfile(f)
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+func writeCoverHTMLFile(fpath string, modules map[string]*gocat.Module, names []string, cover *gocat.Coverage) error {
+	f, err := os.Create(fpath)
+
+	if err != nil {
+		return err
+	}
+
+	err = writeCoverHTML(f, modules, names, cover)
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// loadTestModules loads and type checks the modules in dirs together
+// with their tests. It returns the names of the modules in the order of
+// dirs.
+func loadTestModules(dirs []string) (map[string]*gocat.Module, []string, error) {
+	modules := make(map[string]*gocat.Module)
+	names := make([]string, 0, len(dirs))
+
+	for _, dir := range dirs {
+		module, err := gocat.LoadTestModule(dir)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if modules[module.Name] != nil {
+			return nil, nil, fmt.Errorf("Duplicate module `%s` in `%s` and `%s`.",
+				module.Name, modules[module.Name].Path, dir)
+		}
+
+		modules[module.Name] = module
+		names = append(names, module.Name)
+	}
+
+	err := gocat.TypeCheck(modules)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return modules, names, nil
+}
+
+// tester runs tests and reports their results like go test.
+type tester struct {
+	out     io.Writer
+	verbose bool
+	filter  *regexp.Regexp
+
+	// cover collects the coverage and prof profiles the tests if they
+	// are not nil.
+	cover *gocat.Coverage
+	prof  *gocat.Profiler
+}
+
+// run runs the tests of the modules names. It returns false if a test
+// failed.
+func (t *tester) run(modules map[string]*gocat.Module, names []string) bool {
+	rt := gocat.NewRuntime(modules)
+	rt.SetCoverage(t.cover)
+	rt.SetProfiler(t.prof)
+	initErr := rt.Init()
+	ok := true
+
+	for _, name := range names {
+		start := time.Now()
+		tests := gocat.TestFuncs(modules[name])
+
+		if len(tests) == 0 {
+			fmt.Fprintf(t.out, "?   \t%s\t[no test files]\n", name)
+			continue
+		}
+
+		passed := initErr == nil
+
+		if initErr != nil {
+			fmt.Fprintln(t.out, initErr.Error())
+		} else {
+			for _, test := range tests {
+				fqname := name + ":" + test
+
+				if !t.filter.MatchString(fqname) {
+					continue
+				}
+
+				if !t.runTest(rt, fqname) {
+					passed = false
+				}
+			}
+		}
+
+		took := time.Since(start)
+		coverage := ""
+
+		if t.cover != nil {
+			coverage = fmt.Sprintf("\tcoverage: %.1f%% of statements", coverPercent(sourceBlocks(t.cover, modules[name])))
+		}
+
+		if passed {
+			if t.verbose {
+				fmt.Fprintln(t.out, "PASS")
+			}
+
+			fmt.Fprintf(t.out, "ok  \t%s\t%.3fs%s\n", name, took.Seconds(), coverage)
+		} else {
+			fmt.Fprintln(t.out, "FAIL")
+			fmt.Fprintf(t.out, "FAIL\t%s\t%.3fs%s\n", name, took.Seconds(), coverage)
+			ok = false
+		}
+	}
+
+	return ok
+}
+
+// runTest runs the test fqname. It returns false if the test failed.
+func (t *tester) runTest(rt *gocat.Runtime, fqname string) bool {
+	if t.verbose {
+		fmt.Fprintf(t.out, "=== RUN   %s\n", fqname)
+	}
+
+	start := time.Now()
+	_, err := rt.Exec(fqname, nil)
+	took := time.Since(start)
+
+	if err == nil {
+		if t.verbose {
+			fmt.Fprintf(t.out, "--- PASS: %s (%.2fs)\n", fqname, took.Seconds())
+		}
+
+		return true
+	}
+
+	fmt.Fprintf(t.out, "--- FAIL: %s (%.2fs)\n", fqname, took.Seconds())
+
+	msg := err.Error()
+
+	if re, ok := err.(*gocat.RuntimeError); ok {
+		msg = re.Msg
+
+		if re.Pos != nil {
+			msg = fmt.Sprintf("%s:%d:%d: %s", re.Pos.FilePath, re.Pos.LineNumber, re.Pos.CharNumber, msg)
+		}
+	}
+
+	for _, line := range strings.Split(msg, "\n") {
+		fmt.Fprintf(t.out, "    %s\n", line)
+	}
+
+	return false
+}
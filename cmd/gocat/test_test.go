@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/FMNSSun/gocat"
+)
+
+func TestTest(t *testing.T) {
+	dir := t.TempDir()
+
+	writeFile(filepath.Join(dir, "geo", "geo.gct"), "pub func two [] [int] { 2; }\n", t)
+	writeFile(filepath.Join(dir, "geo", "geo_test.gct"), `
+func test_two [] [] {
+	two 2 assert.eq;
+}
+
+func test_three [] [] {
+	two 3 assert.eq;
+}
+`, t)
+	writeFile(filepath.Join(dir, "app", "app.gct"), "func four [] [int] { geo:two geo:two div.i; }\n", t)
+
+	modules, names, err := loadTestModules([]string{filepath.Join(dir, "geo"), filepath.Join(dir, "app")})
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	var out bytes.Buffer
+
+	tt := &tester{
+		out:     &out,
+		verbose: true,
+		filter:  regexp.MustCompile(""),
+	}
+
+	if tt.run(modules, names) {
+		t.Fatalf("Expected a failure but got:\n%s", out.String())
+	}
+
+	for _, exp := range []string{
+		"=== RUN   geo:test_three\n--- FAIL: geo:test_three",
+		"    " + filepath.Join(dir, "geo", "geo_test.gct") + ":7:",
+		": Assertion failed: 2 is not equal to 3.\n",
+		"--- PASS: geo:test_two",
+		"FAIL\nFAIL\tgeo\t",
+		"?   \tapp\t[no test files]\n",
+	} {
+		if !strings.Contains(out.String(), exp) {
+			t.Fatalf("Expected %q in:\n%s", exp, out.String())
+		}
+	}
+
+	out.Reset()
+	tt.verbose = false
+	tt.filter = regexp.MustCompile("two")
+
+	if !tt.run(modules, names) || !strings.HasPrefix(out.String(), "ok  \tgeo\t") {
+		t.Fatalf("Unexpected output:\n%s", out.String())
+	}
+}
+
+func TestTestCover(t *testing.T) {
+	dir := t.TempDir()
+
+	writeFile(filepath.Join(dir, "geo", "geo.gct"), `pub func abs [(x int)] [int] {
+	if x 0 less {
+		x neg;
+	} else {
+		x;
+	}
+}
+
+func less [(a int) (b int)] [bool] {
+	false;
+}
+
+func neg [(x int)] [int] {
+	0;
+}
+`, t)
+	writeFile(filepath.Join(dir, "geo", "geo_test.gct"), `
+func test_abs [] [] {
+	2 abs 2 assert.eq;
+}
+`, t)
+
+	modules, names, err := loadTestModules([]string{filepath.Join(dir, "geo")})
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	var out bytes.Buffer
+
+	tt := &tester{
+		out:    &out,
+		filter: regexp.MustCompile(""),
+		cover:  gocat.NewCoverage(),
+	}
+
+	// abs has 5 blocks of which 3 are executed, less has 1 and neg has 1
+	// which is not executed.
+	if !tt.run(modules, names) || !strings.HasSuffix(out.String(), "\tcoverage: 57.1% of statements\n") {
+		t.Fatalf("Unexpected output:\n%s", out.String())
+	}
+
+	out.Reset()
+
+	err = writeCoverFuncs(&out, modules, names, tt.cover)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	// The columns are aligned with tabs.
+	funcs := strings.Join(strings.Fields(out.String()), " ")
+
+	for _, exp := range []string{"geo:abs 60.0%", "geo:neg 0.0%", "total: (statements) 57.1%"} {
+		if !strings.Contains(funcs, exp) {
+			t.Fatalf("Expected %q in:\n%s", exp, out.String())
+		}
+	}
+
+	if strings.Contains(out.String(), "test_abs") {
+		t.Fatalf("Unexpected test function in:\n%s", out.String())
+	}
+
+	out.Reset()
+
+	err = writeCoverHTML(&out, modules, names, tt.cover)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	for _, exp := range []string{
+		`<span class="cov" title="1">   2  	if x 0 less {</span>`,
+		`<span class="uncov" title="0">   3  		x neg;</span>`,
+		`<span class="cov" title="1">   5  		x;</span>`,
+		"\n   4  	} else {\n",
+	} {
+		if !strings.Contains(out.String(), exp) {
+			t.Fatalf("Expected %q in:\n%s", exp, out.String())
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package gocat
+
+import (
+	"sort"
+)
+
+// Coverage counts how often the expressions and branches of functions
+// were executed. Expressions are the expressions terminated by `;` and
+// the conditions of ifs. Branches are the blocks of ifs and elses and
+// the cases of matches.
+type Coverage struct {
+	counts map[coverKey]uint64
+}
+
+// coverKey identifies a block. branch is 0 for expressions, 0 or 1 for
+// the blocks of ifs and elses and the index of the case for matches.
+type coverKey struct {
+	node   Node
+	branch int
+}
+
+func NewCoverage() *Coverage {
+	return &Coverage{
+		counts: make(map[coverKey]uint64),
+	}
+}
+
+// SetCoverage makes subsequent executions count executed expressions and
+// branches in cover. A nil cover disables counting.
+func (rt *Runtime) SetCoverage(cover *Coverage) {
+	rt.cover = cover
+}
+
+func (c *Coverage) count(node Node, branch int) {
+	c.counts[coverKey{node, branch}]++
+}
+
+// CoverBlock is an expression or branch of a function.
+type CoverBlock struct {
+	// Func is the fully qualified name of the function.
+	Func  string
+	Pos   *FilePos
+	Count uint64
+}
+
+// Blocks returns the blocks of the functions of the module ordered by
+// their positions.
+func (c *Coverage) Blocks(module *Module) []*CoverBlock {
+	var blocks []*CoverBlock
+
+	for _, name := range sortedNames(module.Funcs) {
+		fn := module.Funcs[name]
+
+		if fn.FuncNode == nil {
+			continue
+		}
+
+		fqname := module.Name + ":" + name
+
+		walkCoverBlocks(fn.FuncNode.Body, fn.FuncNode.Token, func(key coverKey, pos *FilePos) {
+			blocks = append(blocks, &CoverBlock{
+				Func:  fqname,
+				Pos:   pos,
+				Count: c.counts[key],
+			})
+		})
+	}
+
+	sort.SliceStable(blocks, func(i, j int) bool {
+		return lessPos(blocks[i].Pos, blocks[j].Pos)
+	})
+
+	return blocks
+}
+
+// FuncCoverage is the coverage of a function.
+type FuncCoverage struct {
+	Func    string
+	Pos     *FilePos
+	Blocks  int
+	Covered int
+}
+
+// Percent returns the percentage of executed blocks. Functions without
+// blocks are fully covered.
+func (fc *FuncCoverage) Percent() float64 {
+	if fc.Blocks == 0 {
+		return 100
+	}
+
+	return 100 * float64(fc.Covered) / float64(fc.Blocks)
+}
+
+// Funcs returns the coverage of the functions of the module sorted by
+// name.
+func (c *Coverage) Funcs(module *Module) []*FuncCoverage {
+	var funcs []*FuncCoverage
+
+	for _, name := range sortedNames(module.Funcs) {
+		fn := module.Funcs[name]
+
+		if fn.FuncNode == nil {
+			continue
+		}
+
+		fc := &FuncCoverage{
+			Func: module.Name + ":" + name,
+			Pos:  fn.FuncNode.Token.Pos,
+		}
+
+		walkCoverBlocks(fn.FuncNode.Body, fn.FuncNode.Token, func(key coverKey, pos *FilePos) {
+			fc.Blocks++
+
+			if c.counts[key] > 0 {
+				fc.Covered++
+			}
+		})
+
+		funcs = append(funcs, fc)
+	}
+
+	return funcs
+}
+
+// walkCoverBlocks calls visit for the blocks of nodes. Empty branches
+// are positioned at tk.
+func walkCoverBlocks(nodes []Node, tk *Token, visit func(key coverKey, pos *FilePos)) {
+	for _, node := range nodes {
+		switch node := node.(type) {
+		case *ExpNode:
+			visit(coverKey{node, 0}, node.Token.Pos)
+		case *IfElseNode:
+			walkCoverBlocks([]Node{node.Condition}, node.Token, visit)
+			visit(coverKey{node, 0}, blockPos(node.ThenBlock, node.Token))
+			walkCoverBlocks(node.ThenBlock, node.Token, visit)
+			visit(coverKey{node, 1}, blockPos(node.ElseBlock, node.Token))
+			walkCoverBlocks(node.ElseBlock, node.Token, visit)
+		case *MatchNode:
+			for i, mc := range node.Cases {
+				visit(coverKey{node, i}, mc.Token.Pos)
+				walkCoverBlocks(mc.Body, mc.Token, visit)
+			}
+		}
+	}
+}
+
+// blockPos returns the position of the first node of a block or the
+// position of tk if the block is empty.
+func blockPos(nodes []Node, tk *Token) *FilePos {
+	if len(nodes) > 0 {
+		switch node := nodes[0].(type) {
+		case *ExpNode:
+			return node.Token.Pos
+		case *IfElseNode:
+			return node.Token.Pos
+		case *MatchNode:
+			return node.Token.Pos
+		}
+	}
+
+	return tk.Pos
+}
+
+func lessPos(a *FilePos, b *FilePos) bool {
+	if a.FilePath != b.FilePath {
+		return a.FilePath < b.FilePath
+	}
+
+	if a.LineNumber != b.LineNumber {
+		return a.LineNumber < b.LineNumber
+	}
+
+	return a.CharNumber < b.CharNumber
+}
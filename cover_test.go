@@ -0,0 +1,97 @@
+package gocat
+
+import (
+	"testing"
+)
+
+func TestCoverage(t *testing.T) {
+	code := `
+func sign [(x int)] [int] {
+	if x 0 test:less {
+		-1;
+	} else {
+		1;
+	}
+}
+
+func less [(a int) (b int)] [bool] {
+	true;
+}
+
+func kind [(x {int string})] [string] {
+	x;
+	match {
+		int {
+			test:drop "int";
+		}
+		string {
+			test:dropstr "string";
+		}
+	}
+}
+
+func drop [(x int)] [] {
+}
+
+func dropstr [(x string)] [] {
+}
+`
+	modules, err := loadTestModules(map[string]string{"test": code})
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	cover := NewCoverage()
+
+	rt := NewRuntime(modules)
+	rt.SetCoverage(cover)
+
+	for _, fqname := range []string{"test:sign", "test:kind"} {
+		_, err = rt.Exec(fqname, []Value{int64(1)})
+
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+	}
+
+	exp := map[string][2]int{
+		// The condition, both branches, `-1;` and `1;`.
+		"test:sign": {5, 3},
+		"test:less": {1, 1},
+		// `x;`, both cases and their bodies.
+		"test:kind":    {5, 3},
+		"test:drop":    {0, 0},
+		"test:dropstr": {0, 0},
+	}
+
+	funcs := cover.Funcs(modules["test"])
+
+	if len(funcs) != len(exp) {
+		t.Fatalf("Unexpected functions: %v", funcs)
+	}
+
+	for _, fc := range funcs {
+		if exp[fc.Func] != [2]int{fc.Blocks, fc.Covered} {
+			t.Fatalf("Expected %v but got %d blocks and %d covered for %s.", exp[fc.Func], fc.Blocks, fc.Covered, fc.Func)
+		}
+	}
+
+	if funcs[3].Func != "test:less" || funcs[3].Percent() != 100 || funcs[4].Percent() != 60 {
+		t.Fatalf("Unexpected percentages.")
+	}
+
+	blocks := cover.Blocks(modules["test"])
+
+	for i := 1; i < len(blocks); i++ {
+		if lessPos(blocks[i].Pos, blocks[i-1].Pos) {
+			t.Fatalf("Blocks are not ordered by position.")
+		}
+	}
+
+	// The first block is the condition of the if in sign which was
+	// executed once.
+	if blocks[0].Func != "test:sign" || blocks[0].Pos.LineNumber != 3 || blocks[0].Count != 1 {
+		t.Fatalf("Unexpected first block: %v", blocks[0])
+	}
+}
@@ -0,0 +1,227 @@
+package gocat
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+)
+
+// ErrAborted is returned by executions aborted by a debugger. It can't
+// be caught by `try`.
+var ErrAborted = errors.New("Execution aborted by the debugger.")
+
+// DebugAction tells a paused execution how to continue.
+type DebugAction int
+
+const (
+	// DebugContinue continues until the next breakpoint.
+	DebugContinue DebugAction = iota
+
+	// DebugStepInto pauses at the next literal or verb including the
+	// ones of called functions.
+	DebugStepInto
+
+	// DebugStepOver pauses at the next literal or verb of the current
+	// function or of its callers.
+	DebugStepOver
+
+	// DebugStepOut pauses at the next literal or verb of a caller of the
+	// current function.
+	DebugStepOut
+
+	// DebugAbort aborts the execution with ErrAborted.
+	DebugAbort
+)
+
+// DebugState is the state of a paused execution.
+type DebugState struct {
+	// Pos is the position of the literal or verb executed next.
+	Pos *FilePos
+
+	// Frames is the gocat call stack with the innermost frame first.
+	Frames []Frame
+
+	// Stack are the values on the value stack of the current function
+	// with the top of the stack last.
+	Stack []Value
+
+	// Args are the arguments of the current function.
+	Args map[string]Value
+}
+
+// Debugger pauses executions at breakpoints and after steps. While the
+// execution is paused the runtime calls Pause which returns how to
+// continue.
+type Debugger struct {
+	Pause func(state *DebugState) DebugAction
+
+	breakpoints []*Breakpoint
+
+	action DebugAction
+	depth  int
+
+	// lastFrame and lastLine are the frame and line of the last check
+	// which avoids hitting the same breakpoint repeatedly.
+	lastFrame *frame
+	lastLine  uint32
+}
+
+// Breakpoint pauses an execution whenever it reaches the line Line of
+// the file FilePath.
+type Breakpoint struct {
+	FilePath string
+	Line     uint32
+}
+
+func NewDebugger(pause func(state *DebugState) DebugAction) *Debugger {
+	return &Debugger{
+		Pause: pause,
+	}
+}
+
+// SetDebugger attaches the debugger d to subsequent executions. A nil d
+// detaches the debugger.
+func (rt *Runtime) SetDebugger(d *Debugger) {
+	rt.debug = d
+}
+
+// SetBreakpoint sets a breakpoint at the line of the file fpath. fpath
+// matches files whose paths are fpath or end with fpath.
+func (d *Debugger) SetBreakpoint(fpath string, line uint32) {
+	for _, bp := range d.breakpoints {
+		if bp.FilePath == fpath && bp.Line == line {
+			return
+		}
+	}
+
+	d.breakpoints = append(d.breakpoints, &Breakpoint{
+		FilePath: fpath,
+		Line:     line,
+	})
+}
+
+// ClearBreakpoint removes the breakpoint at the line of the file fpath.
+// It returns false if there is no such breakpoint.
+func (d *Debugger) ClearBreakpoint(fpath string, line uint32) bool {
+	for i, bp := range d.breakpoints {
+		if bp.FilePath == fpath && bp.Line == line {
+			d.breakpoints = append(d.breakpoints[:i], d.breakpoints[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// Breakpoints returns the breakpoints in the order they were set.
+func (d *Debugger) Breakpoints() []*Breakpoint {
+	return d.breakpoints
+}
+
+// Break makes the execution pause at the next literal or verb.
+func (d *Debugger) Break() {
+	d.action = DebugStepInto
+}
+
+func (bp *Breakpoint) matches(pos *FilePos) bool {
+	if pos.LineNumber != bp.Line {
+		return false
+	}
+
+	fpath := filepath.ToSlash(pos.FilePath)
+	bpath := filepath.ToSlash(bp.FilePath)
+
+	return fpath == bpath || strings.HasSuffix(fpath, "/"+bpath)
+}
+
+// check is called before the runtime executes the literal or verb node
+// of the function executing in fr. It pauses the execution if needed.
+func (d *Debugger) check(rt *Runtime, fr *frame, node Node, stack []Value) error {
+	pos := dataPos(node)
+
+	if pos == nil {
+		return nil
+	}
+
+	depth := len(rt.frames)
+
+	pause := false
+
+	switch d.action {
+	case DebugStepInto:
+		pause = true
+	case DebugStepOver:
+		pause = depth <= d.depth
+	case DebugStepOut:
+		pause = depth < d.depth
+	}
+
+	if fr != d.lastFrame || pos.LineNumber != d.lastLine {
+		for _, bp := range d.breakpoints {
+			if bp.matches(pos) {
+				pause = true
+			}
+		}
+	}
+
+	d.lastFrame = fr
+	d.lastLine = pos.LineNumber
+
+	if !pause || d.Pause == nil {
+		return nil
+	}
+
+	frames := rt.stackTrace()
+	frames[0].Pos = pos
+
+	args := make(map[string]Value, len(fr.args))
+
+	for name, arg := range fr.args {
+		args[name] = arg
+	}
+
+	d.depth = depth
+	d.action = d.Pause(&DebugState{
+		Pos:    pos,
+		Frames: frames,
+		Stack:  append([]Value(nil), stack...),
+		Args:   args,
+	})
+
+	if d.action == DebugAbort {
+		d.action = DebugContinue
+		return ErrAborted
+	}
+
+	return nil
+}
+
+// dataPos returns the position of a literal or verb node.
+func dataPos(node Node) *FilePos {
+	var tk *Token
+
+	switch node := node.(type) {
+	case *LitIntNode:
+		tk = node.Token
+	case *LitFloatNode:
+		tk = node.Token
+	case *LitBoolNode:
+		tk = node.Token
+	case *LitStringNode:
+		tk = node.Token
+	case *LitListNode:
+		tk = node.Token
+	case *LitMapNode:
+		tk = node.Token
+	case *QuotNode:
+		tk = node.Token
+	case *VerbNode:
+		tk = node.Token
+	}
+
+	if tk == nil {
+		return nil
+	}
+
+	return tk.Pos
+}
@@ -0,0 +1,168 @@
+package gocat
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const debugTestCode = `func main [] [int] {
+	2 test:double
+	1 test:add;
+}
+
+func double [(x int)] [int] {
+	x x test:add;
+}
+
+func add [(a int) (b int)] [int] {
+	a b
+	test:plus;
+}
+
+func plus [(a int) (b int)] [int] {
+	a square.i b square.i test:minus;
+}
+
+func minus [(a int) (b int)] [int] {
+	a;
+}
+`
+
+// debugTrace runs test:main with a debugger which continues with the
+// actions and records the function and line of each pause.
+func debugTrace(modules map[string]*Module, breakpoints []uint32, actions []DebugAction, t *testing.T) ([]string, error) {
+	var trace []string
+
+	d := NewDebugger(func(state *DebugState) DebugAction {
+		trace = append(trace, fmt.Sprintf("%s:%d", state.Frames[0].Func, state.Pos.LineNumber))
+
+		if len(actions) == 0 {
+			return DebugContinue
+		}
+
+		action := actions[0]
+		actions = actions[1:]
+
+		return action
+	})
+
+	for _, line := range breakpoints {
+		d.SetBreakpoint("<memory>", line)
+	}
+
+	if len(breakpoints) == 0 {
+		d.Break()
+	}
+
+	rt := NewRuntime(modules)
+	rt.SetDebugger(d)
+
+	_, err := rt.Exec("test:main", nil)
+
+	return trace, err
+}
+
+func TestDebugger(t *testing.T) {
+	modules, err := loadTestModules(map[string]string{"test": debugTestCode})
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	tests := []struct {
+		breakpoints []uint32
+		actions     []DebugAction
+		exp         string
+	}{
+		{
+			actions: []DebugAction{DebugStepInto, DebugStepInto, DebugStepInto, DebugStepInto},
+			exp:     "test:main:2 test:main:2 test:double:7 test:double:7 test:double:7",
+		},
+		{
+			actions: []DebugAction{DebugStepOver, DebugStepOver, DebugStepOver, DebugStepOver},
+			exp:     "test:main:2 test:main:2 test:main:3 test:main:3",
+		},
+		{
+			actions: []DebugAction{DebugStepInto, DebugStepInto, DebugStepOut},
+			exp:     "test:main:2 test:main:2 test:double:7 test:main:3",
+		},
+		{
+			breakpoints: []uint32{11, 16},
+			exp:         "test:add:11 test:plus:16 test:add:11 test:plus:16",
+		},
+		{
+			breakpoints: []uint32{11},
+			actions:     []DebugAction{DebugStepOver, DebugStepOver, DebugStepOver},
+			exp:         "test:add:11 test:add:11 test:add:12 test:main:3 test:add:11",
+		},
+	}
+
+	for _, test := range tests {
+		trace, err := debugTrace(modules, test.breakpoints, test.actions, t)
+
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+
+		if strings.Join(trace, " ") != test.exp {
+			t.Fatalf("Expected %s but got %s.", test.exp, strings.Join(trace, " "))
+		}
+	}
+
+	trace, err := debugTrace(modules, []uint32{16}, []DebugAction{DebugAbort}, t)
+
+	if !errors.Is(err, ErrAborted) || len(trace) != 1 {
+		t.Fatalf("Expected an aborted execution but got: %v %v", err, trace)
+	}
+}
+
+func TestDebuggerState(t *testing.T) {
+	modules, err := loadTestModules(map[string]string{"test": debugTestCode})
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	var state *DebugState
+
+	d := NewDebugger(func(st *DebugState) DebugAction {
+		if state == nil {
+			state = st
+		}
+
+		return DebugContinue
+	})
+
+	d.SetBreakpoint("<memory>", 16)
+	d.ClearBreakpoint("<memory>", 16)
+	d.SetBreakpoint("<memory>", 20)
+
+	rt := NewRuntime(modules)
+	rt.SetDebugger(d)
+
+	rets, err := rt.Exec("test:main", nil)
+
+	if err != nil || len(rets) != 1 || rets[0] != int64(16) {
+		t.Fatalf("Unexpected result: %v %v", rets, err)
+	}
+
+	if len(d.Breakpoints()) != 1 {
+		t.Fatalf("Unexpected breakpoints: %v", d.Breakpoints())
+	}
+
+	funcs := make([]string, len(state.Frames))
+
+	for i, fr := range state.Frames {
+		funcs[i] = fr.Func
+	}
+
+	if strings.Join(funcs, " ") != "test:minus test:plus test:add test:double test:main" {
+		t.Fatalf("Unexpected frames: %v", funcs)
+	}
+
+	if len(state.Stack) != 0 || state.Args["a"] != int64(4) || state.Args["b"] != int64(4) {
+		t.Fatalf("Unexpected state: %v %v", state.Stack, state.Args)
+	}
+}
@@ -0,0 +1,558 @@
+package gocat
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Call calls the function with the fully qualified name fqname with Go
+// values as arguments. The arguments are converted to gocat values
+// according to the argument types of the function and the returned
+// values are converted to Go values: ints to int64, floats to float64,
+// lists to []any and maps to map[any]any. Records, errors and
+// quotations are returned as their gocat values.
+func (rt *Runtime) Call(fqname string, args ...any) ([]any, error) {
+	fn := rt.lookupFunc(fqname)
+
+	if fn == nil {
+		return nil, fmt.Errorf("Function `%s` does not exist!", fqname)
+	}
+
+	if len(args) != len(fn.Type.ArgTypes) {
+		return nil, fmt.Errorf("Function `%s` takes %d arguments but got %d.",
+			fqname, len(fn.Type.ArgTypes), len(args))
+	}
+
+	vals := make([]Value, len(args))
+
+	for i, arg := range args {
+		val, err := ToValue(arg, fn.Type.ArgTypes[i])
+
+		if err != nil {
+			return nil, fmt.Errorf("Argument %d of `%s`: %s", i+1, fqname, err.Error())
+		}
+
+		vals[i] = val
+	}
+
+	rets, err := rt.Exec(fqname, vals)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rets) != len(fn.Type.RetTypes) {
+		return nil, fmt.Errorf("Function `%s` returned %d values but should return %d.",
+			fqname, len(rets), len(fn.Type.RetTypes))
+	}
+
+	results := make([]any, len(rets))
+
+	for i, ret := range rets {
+		if !valueHasType(ret, fn.Type.RetTypes[i]) {
+			return nil, fmt.Errorf("Return value %d of `%s` is of type `%s` but should be of type `%s`.",
+				i+1, fqname, typeOf(ret), fn.Type.RetTypes[i])
+		}
+
+		results[i] = FromValue(ret)
+	}
+
+	return results, nil
+}
+
+// Decode stores the values returned by Call in the values pointed to by
+// ptrs. There must be exactly one pointer per value.
+func Decode(results []any, ptrs ...any) error {
+	if len(results) != len(ptrs) {
+		return fmt.Errorf("Can't decode %d values into %d pointers.", len(results), len(ptrs))
+	}
+
+	for i, ptr := range ptrs {
+		rv := reflect.ValueOf(ptr)
+
+		if rv.Kind() != reflect.Ptr || rv.IsNil() {
+			return fmt.Errorf("Can't decode value %d into non-pointer %T.", i+1, ptr)
+		}
+
+		err := decode(results[i], rv.Elem())
+
+		if err != nil {
+			return fmt.Errorf("Value %d: %s", i+1, err.Error())
+		}
+	}
+
+	return nil
+}
+
+func decode(v any, dst reflect.Value) error {
+	if v == nil {
+		return fmt.Errorf("Can't decode nil.")
+	}
+
+	src := reflect.ValueOf(v)
+
+	if dst.Kind() == reflect.Interface {
+		if !src.Type().Implements(dst.Type()) {
+			return fmt.Errorf("Can't decode %T into %s.", v, dst.Type())
+		}
+
+		dst.Set(src)
+		return nil
+	}
+
+	switch v.(type) {
+	case int64:
+		switch dst.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if dst.OverflowInt(v.(int64)) {
+				return fmt.Errorf("%d overflows %s.", v, dst.Type())
+			}
+
+			dst.SetInt(v.(int64))
+			return nil
+		}
+	case float64:
+		switch dst.Kind() {
+		case reflect.Float32, reflect.Float64:
+			dst.SetFloat(v.(float64))
+			return nil
+		}
+	case bool:
+		if dst.Kind() == reflect.Bool {
+			dst.SetBool(v.(bool))
+			return nil
+		}
+	case string:
+		if dst.Kind() == reflect.String {
+			dst.SetString(v.(string))
+			return nil
+		}
+	case []any:
+		if dst.Kind() == reflect.Slice {
+			elems := v.([]any)
+			slice := reflect.MakeSlice(dst.Type(), len(elems), len(elems))
+
+			for i, elem := range elems {
+				err := decode(elem, slice.Index(i))
+
+				if err != nil {
+					return err
+				}
+			}
+
+			dst.Set(slice)
+			return nil
+		}
+	case map[any]any:
+		if dst.Kind() == reflect.Map {
+			entries := v.(map[any]any)
+			m := reflect.MakeMapWithSize(dst.Type(), len(entries))
+
+			for k, e := range entries {
+				key := reflect.New(dst.Type().Key()).Elem()
+				err := decode(k, key)
+
+				if err != nil {
+					return err
+				}
+
+				elem := reflect.New(dst.Type().Elem()).Elem()
+				err = decode(e, elem)
+
+				if err != nil {
+					return err
+				}
+
+				m.SetMapIndex(key, elem)
+			}
+
+			dst.Set(m)
+			return nil
+		}
+	default:
+		if src.Type().AssignableTo(dst.Type()) {
+			dst.Set(src)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Can't decode %T into %s.", v, dst.Type())
+}
+
+// FromValue converts a gocat value to a Go value. Ints become int64,
+// floats become float64, lists become []any and maps become map[any]any.
+// Other values are returned as they are.
+func FromValue(v Value) any {
+	switch v.(type) {
+	case *ListValue:
+		lv := v.(*ListValue)
+		elems := make([]any, len(lv.Elems))
+
+		for i, elem := range lv.Elems {
+			elems[i] = FromValue(elem)
+		}
+
+		return elems
+	case *MapValue:
+		mv := v.(*MapValue)
+		entries := make(map[any]any, len(mv.Entries))
+
+		for k, e := range mv.Entries {
+			entries[k] = FromValue(e)
+		}
+
+		return entries
+	}
+
+	return v
+}
+
+// ToValue converts a Go value to a gocat value of type typ. Go integers
+// convert to int, Go floats to float, slices to lists and maps to maps.
+// gocat values of the right type are used as they are.
+func ToValue(v any, typ Type) (Value, error) {
+	if v == nil {
+		return nil, fmt.Errorf("Can't use nil as `%s`.", typ)
+	}
+
+	// gocat values are used as they are. Collections are checked
+	// element wise below because Go slices and maps have no gocat type.
+	switch v.(type) {
+	case *RecordValue, *ErrorValue, *QuotValue, *ListValue, *MapValue:
+		if valueHasType(v, typ) {
+			return v, nil
+		}
+
+		return nil, fmt.Errorf("Can't use value of type `%s` as `%s`.", typeOf(v), typ)
+	}
+
+	switch typ.(type) {
+	case *UnionType:
+		for _, member := range typ.(*UnionType).Types {
+			val, err := ToValue(v, member)
+
+			if err == nil {
+				return val, nil
+			}
+		}
+	case *PrimType:
+		rv := reflect.ValueOf(v)
+
+		switch typ.(*PrimType).Type {
+		case "int":
+			switch rv.Kind() {
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+				return rv.Int(), nil
+			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+				u := rv.Uint()
+
+				if int64(u) < 0 {
+					return nil, fmt.Errorf("%d overflows `int`.", u)
+				}
+
+				return int64(u), nil
+			}
+		case "float":
+			switch rv.Kind() {
+			case reflect.Float32, reflect.Float64:
+				return rv.Float(), nil
+			}
+		case "bool":
+			if rv.Kind() == reflect.Bool {
+				return rv.Bool(), nil
+			}
+		case "string":
+			if rv.Kind() == reflect.String {
+				return rv.String(), nil
+			}
+		}
+	case *ListType:
+		lt := typ.(*ListType)
+		rv := reflect.ValueOf(v)
+
+		if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+			elems := make([]Value, rv.Len())
+
+			for i := 0; i < rv.Len(); i++ {
+				elem, err := ToValue(rv.Index(i).Interface(), lt.ElemType)
+
+				if err != nil {
+					return nil, err
+				}
+
+				elems[i] = elem
+			}
+
+			return &ListValue{
+				Type:  lt,
+				Elems: elems,
+			}, nil
+		}
+	case *MapType:
+		mt := typ.(*MapType)
+		rv := reflect.ValueOf(v)
+
+		if rv.Kind() == reflect.Map {
+			entries := make(map[Value]Value, rv.Len())
+			iter := rv.MapRange()
+
+			for iter.Next() {
+				key, err := ToValue(iter.Key().Interface(), mt.KeyType)
+
+				if err != nil {
+					return nil, err
+				}
+
+				elem, err := ToValue(iter.Value().Interface(), mt.ValueType)
+
+				if err != nil {
+					return nil, err
+				}
+
+				entries[key] = elem
+			}
+
+			return &MapValue{
+				Type:    mt,
+				Entries: entries,
+			}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Can't use Go value of type %T as `%s`.", v, typ)
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// Bind returns a Go function of type F calling the gocat function with
+// the fully qualified name fqname. F must take one parameter per argument
+// of the gocat function and return one value per returned value of the
+// gocat function optionally followed by an error. The parameters must
+// be convertible by ToValue and the results must be able to hold the
+// values returned by Call. If F does not return an error the returned
+// function panics if the call fails.
+func Bind[F any](rt *Runtime, fqname string) (F, error) {
+	var f F
+
+	ft := reflect.TypeOf(&f).Elem()
+
+	if ft.Kind() != reflect.Func {
+		return f, fmt.Errorf("Can't bind `%s` to non-function %s.", fqname, ft)
+	}
+
+	fn := rt.lookupFunc(fqname)
+
+	if fn == nil {
+		return f, fmt.Errorf("Function `%s` does not exist!", fqname)
+	}
+
+	if ft.NumIn() != len(fn.Type.ArgTypes) || ft.IsVariadic() {
+		return f, fmt.Errorf("Can't bind `%s` of type `%s` to %s: wrong number of parameters.", fqname, fn.Type, ft)
+	}
+
+	numOut := ft.NumOut()
+	withErr := numOut > 0 && ft.Out(numOut-1) == errorType
+
+	if withErr {
+		numOut--
+	}
+
+	if numOut != len(fn.Type.RetTypes) {
+		return f, fmt.Errorf("Can't bind `%s` of type `%s` to %s: wrong number of results.", fqname, fn.Type, ft)
+	}
+
+	for i, typ := range fn.Type.ArgTypes {
+		if !argFits(ft.In(i), typ) {
+			return f, fmt.Errorf("Can't bind `%s` of type `%s` to %s: parameter %d of type %s can't be used as `%s`.",
+				fqname, fn.Type, ft, i+1, ft.In(i), typ)
+		}
+	}
+
+	for i, typ := range fn.Type.RetTypes {
+		if !resultFits(ft.Out(i), typ) {
+			return f, fmt.Errorf("Can't bind `%s` of type `%s` to %s: result %d of type %s can't hold `%s`.",
+				fqname, fn.Type, ft, i+1, ft.Out(i), typ)
+		}
+	}
+
+	impl := reflect.MakeFunc(ft, func(in []reflect.Value) []reflect.Value {
+		out := make([]reflect.Value, ft.NumOut())
+
+		for i := 0; i < ft.NumOut(); i++ {
+			out[i] = reflect.New(ft.Out(i)).Elem()
+		}
+
+		fail := func(err error) []reflect.Value {
+			if !withErr {
+				panic(err)
+			}
+
+			out[len(out)-1] = reflect.ValueOf(&err).Elem()
+			return out
+		}
+
+		args := make([]any, len(in))
+
+		for i, v := range in {
+			args[i] = v.Interface()
+		}
+
+		results, err := rt.Call(fqname, args...)
+
+		if err != nil {
+			return fail(err)
+		}
+
+		for i, result := range results {
+			err = decode(result, out[i])
+
+			if err != nil {
+				return fail(fmt.Errorf("Result %d of `%s`: %s", i+1, fqname, err.Error()))
+			}
+		}
+
+		return out
+	})
+
+	return impl.Interface().(F), nil
+}
+
+var (
+	listValueType   = reflect.TypeOf((*ListValue)(nil))
+	mapValueType    = reflect.TypeOf((*MapValue)(nil))
+	recordValueType = reflect.TypeOf((*RecordValue)(nil))
+	errorValueType  = reflect.TypeOf((*ErrorValue)(nil))
+	quotValueType   = reflect.TypeOf((*QuotValue)(nil))
+)
+
+// argFits returns true if ToValue can convert values of the Go type gt
+// to values of the gocat type typ. Values of interface types are only
+// known once they are converted.
+func argFits(gt reflect.Type, typ Type) bool {
+	if gt.Kind() == reflect.Interface {
+		return true
+	}
+
+	switch typ := typ.(type) {
+	case *UnionType:
+		for _, member := range typ.Types {
+			if argFits(gt, member) {
+				return true
+			}
+		}
+	case *PrimType:
+		switch typ.Type {
+		case "int":
+			switch gt.Kind() {
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+				reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+				return true
+			}
+		case "float":
+			return gt.Kind() == reflect.Float32 || gt.Kind() == reflect.Float64
+		case "bool":
+			return gt.Kind() == reflect.Bool
+		case "string":
+			return gt.Kind() == reflect.String
+		case "error":
+			return gt == errorValueType
+		}
+	case *ListType:
+		if gt.Kind() == reflect.Slice || gt.Kind() == reflect.Array {
+			return argFits(gt.Elem(), typ.ElemType)
+		}
+
+		return gt == listValueType
+	case *MapType:
+		if gt.Kind() == reflect.Map {
+			return argFits(gt.Key(), typ.KeyType) && argFits(gt.Elem(), typ.ValueType)
+		}
+
+		return gt == mapValueType
+	case *RecordType, *ContractType:
+		return gt == recordValueType
+	case *FuncType:
+		return gt == quotValueType
+	}
+
+	return false
+}
+
+// resultFits returns true if values of the gocat type typ returned by
+// Call can be decoded into the Go type gt.
+func resultFits(gt reflect.Type, typ Type) bool {
+	switch typ := typ.(type) {
+	case *UnionType:
+		// Any member may be returned.
+		for _, member := range typ.Types {
+			if !resultFits(gt, member) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	if gt.Kind() == reflect.Interface {
+		return resultGoType(typ).Implements(gt)
+	}
+
+	switch typ := typ.(type) {
+	case *PrimType:
+		switch typ.Type {
+		case "int":
+			switch gt.Kind() {
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+				return true
+			}
+		case "float":
+			return gt.Kind() == reflect.Float32 || gt.Kind() == reflect.Float64
+		case "bool":
+			return gt.Kind() == reflect.Bool
+		case "string":
+			return gt.Kind() == reflect.String
+		case "error":
+			return errorValueType.AssignableTo(gt)
+		}
+	case *ListType:
+		return gt.Kind() == reflect.Slice && resultFits(gt.Elem(), typ.ElemType)
+	case *MapType:
+		return gt.Kind() == reflect.Map && resultFits(gt.Key(), typ.KeyType) && resultFits(gt.Elem(), typ.ValueType)
+	case *RecordType, *ContractType:
+		return recordValueType.AssignableTo(gt)
+	case *FuncType:
+		return quotValueType.AssignableTo(gt)
+	}
+
+	return false
+}
+
+// resultGoType returns the Go type of the values of type typ returned
+// by Call.
+func resultGoType(typ Type) reflect.Type {
+	switch typ := typ.(type) {
+	case *PrimType:
+		switch typ.Type {
+		case "int":
+			return reflect.TypeOf(int64(0))
+		case "float":
+			return reflect.TypeOf(float64(0))
+		case "bool":
+			return reflect.TypeOf(false)
+		case "string":
+			return reflect.TypeOf("")
+		case "error":
+			return errorValueType
+		}
+	case *ListType:
+		return reflect.TypeOf([]any(nil))
+	case *MapType:
+		return reflect.TypeOf(map[any]any(nil))
+	case *RecordType, *ContractType:
+		return recordValueType
+	case *FuncType:
+		return quotValueType
+	}
+
+	return reflect.TypeOf((*Value)(nil)).Elem()
+}
@@ -0,0 +1,253 @@
+package gocat
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const embedTestCode = `
+func square [(a int)] [int] {
+	a square.i;
+}
+
+func scale [(a int) (f float)] [int float] {
+	a f;
+}
+
+func sum [(xs list<int>)] [int] {
+	0;
+}
+
+func lookup [(m map<string int>) (k string)] [int] {
+	m k get;
+}
+
+func names [] [list<string>] {
+	list<string>["a" "b"];
+}
+
+func either [(v {int string})] [{int string}] {
+	v;
+}
+
+func broken [(a int)] [int] {
+	"broken" fail;
+}
+`
+
+func TestCall(t *testing.T) {
+	rt := NewRuntime(mustLoadTestModule("test", embedTestCode, t))
+
+	rets, err := rt.Call("test:square", 3)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	if !reflect.DeepEqual(rets, []any{int64(9)}) {
+		t.Fatalf("Unexpected results: %v", rets)
+	}
+
+	rets, err = rt.Call("test:scale", int32(3), 4.5)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	var a int
+	var f float32
+
+	err = Decode(rets, &a, &f)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	if a != 3 || f != 4.5 {
+		t.Fatalf("Unexpected results: %d %f", a, f)
+	}
+
+	rets, err = rt.Call("test:lookup", map[string]int{"x": 7}, "x")
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	if !reflect.DeepEqual(rets, []any{int64(7)}) {
+		t.Fatalf("Unexpected results: %v", rets)
+	}
+
+	rets, err = rt.Call("test:names")
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	var names []string
+
+	err = Decode(rets, &names)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	if !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Fatalf("Unexpected results: %v", names)
+	}
+
+	rets, err = rt.Call("test:either", "s")
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	if !reflect.DeepEqual(rets, []any{"s"}) {
+		t.Fatalf("Unexpected results: %v", rets)
+	}
+
+	mustErrorCall(rt, "test:square", []any{"3"}, t)
+	mustErrorCall(rt, "test:square", []any{3.0}, t)
+	mustErrorCall(rt, "test:square", []any{3, 4}, t)
+	mustErrorCall(rt, "test:square", nil, t)
+	mustErrorCall(rt, "test:sum", []any{[]string{"a"}}, t)
+	mustErrorCall(rt, "test:either", []any{true}, t)
+	mustErrorCall(rt, "test:broken", []any{1}, t)
+	mustErrorCall(rt, "test:doesnotexist", nil, t)
+
+	rets, _ = rt.Call("test:square", 3)
+
+	var s string
+
+	if Decode(rets, &s) == nil {
+		t.Fatalf("Expected an error decoding an int into a string.")
+	}
+
+	if Decode(rets, a) == nil {
+		t.Fatalf("Expected an error decoding into a non-pointer.")
+	}
+}
+
+func TestBind(t *testing.T) {
+	rt := NewRuntime(mustLoadTestModule("test", embedTestCode, t))
+
+	square, err := Bind[func(int64) int64](rt, "test:square")
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	if square(4) != 16 {
+		t.Fatalf("Unexpected result: %d", square(4))
+	}
+
+	scale, err := Bind[func(int, float64) (int, float64, error)](rt, "test:scale")
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	a, f, err := scale(2, 1.5)
+
+	if err != nil || a != 2 || f != 1.5 {
+		t.Fatalf("Unexpected results: %d %f %v", a, f, err)
+	}
+
+	broken, err := Bind[func(int) (int, error)](rt, "test:broken")
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	_, err = broken(1)
+
+	if err == nil {
+		t.Fatalf("Expected an error but got none.")
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("Expected a panic but got none.")
+			}
+		}()
+
+		broken, _ := Bind[func(int) int](rt, "test:broken")
+		broken(1)
+	}()
+
+	if _, err := Bind[func(int) int](rt, "test:scale"); err == nil {
+		t.Fatalf("Expected an error binding with the wrong number of parameters.")
+	}
+
+	if _, err := Bind[func(int, float64) int](rt, "test:scale"); err == nil {
+		t.Fatalf("Expected an error binding with the wrong number of results.")
+	}
+
+	mismatched := map[string]func() error{
+		"func(string) int64": func() error {
+			_, err := Bind[func(string) int64](rt, "test:square")
+			return err
+		},
+		"func(int64) string": func() error {
+			_, err := Bind[func(int64) string](rt, "test:square")
+			return err
+		},
+		"func(int, int) (int, float64)": func() error {
+			_, err := Bind[func(int, int) (int, float64)](rt, "test:scale")
+			return err
+		},
+		"func([]string) int": func() error {
+			_, err := Bind[func([]string) int](rt, "test:sum")
+			return err
+		},
+		"func(map[int]int, string) int": func() error {
+			_, err := Bind[func(map[int]int, string) int](rt, "test:lookup")
+			return err
+		},
+		"func() []int": func() error {
+			_, err := Bind[func() []int](rt, "test:names")
+			return err
+		},
+		"func(int) int": func() error {
+			_, err := Bind[func(int) int](rt, "test:either")
+			return err
+		},
+	}
+
+	for name, bind := range mismatched {
+		err := bind()
+
+		if err == nil || !strings.Contains(err.Error(), "can't") {
+			t.Fatalf("Expected an error binding %s but got: %v", name, err)
+		}
+	}
+
+	names, err := Bind[func() []string](rt, "test:names")
+
+	if err != nil || !reflect.DeepEqual(names(), []string{"a", "b"}) {
+		t.Fatalf("Unexpected result: %v", err)
+	}
+
+	either, err := Bind[func(any) any](rt, "test:either")
+
+	if err != nil || either("x") != "x" {
+		t.Fatalf("Unexpected result: %v", err)
+	}
+
+	if _, err := Bind[int](rt, "test:square"); err == nil {
+		t.Fatalf("Expected an error binding to a non-function.")
+	}
+
+	if _, err := Bind[func() int](rt, "test:doesnotexist"); err == nil {
+		t.Fatalf("Expected an error binding a function that does not exist.")
+	}
+}
+
+func mustErrorCall(rt *Runtime, fqname string, args []any, t *testing.T) {
+	_, err := rt.Call(fqname, args...)
+
+	if err == nil {
+		t.Fatalf("Expected an error calling `%s` with %v but got none.", fqname, args)
+	}
+}
@@ -0,0 +1,130 @@
+package gocat
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Capabilities is a set of capabilities granted to an execution. Host
+// functions requiring a capability that is not granted are invisible
+// to the type checker and the runtime.
+type Capabilities map[string]bool
+
+func NewCapabilities(caps ...string) Capabilities {
+	c := make(Capabilities)
+
+	for _, cap := range caps {
+		c[cap] = true
+	}
+
+	return c
+}
+
+// Has returns true if the capability is granted. The empty capability
+// is always granted.
+func (c Capabilities) Has(cap string) bool {
+	return cap == "" || c[cap]
+}
+
+// Host holds the functions the embedding Go program provides to gocat
+// code in addition to the builtins.
+type Host struct {
+	funcs map[string]*hostFunc
+}
+
+type hostFunc struct {
+	capability string
+	typ        *FuncType
+	impl       BuiltinFunc
+}
+
+func NewHost() *Host {
+	return &Host{
+		funcs: make(map[string]*hostFunc),
+	}
+}
+
+// Register registers a host function which requires the capability
+// capability. Functions registered with the empty capability are
+// available to all executions. Host functions which block or run for a
+// long time should observe rt.Context() and return its error once it is
+// done as the runtime can only abort an execution between steps. Host
+// functions creating strings, lists or maps should account for them
+// with rt.AllocString or rt.Alloc.
+func (h *Host) Register(name string, capability string, typ *FuncType, impl BuiltinFunc) error {
+	if strings.ContainsRune(name, ':') {
+		return fmt.Errorf("`:` is not allowed in names of host functions. Offending name is `%s`.", name)
+	}
+
+	if builtins[name] != nil || genericBuiltins[name] != nil {
+		return fmt.Errorf("Host function `%s` clashes with a builtin.", name)
+	}
+
+	if h.funcs[name] != nil {
+		return fmt.Errorf("Duplicate host function `%s`.", name)
+	}
+
+	h.funcs[name] = &hostFunc{
+		capability: capability,
+		typ:        typ,
+		impl:       impl,
+	}
+
+	return nil
+}
+
+// TypeWorld returns the types of the host functions visible with the
+// capabilities caps.
+func (h *Host) TypeWorld(caps Capabilities) TypeWorld {
+	tw := make(TypeWorld)
+
+	if h == nil {
+		return tw
+	}
+
+	for name, hf := range h.funcs {
+		if caps.Has(hf.capability) {
+			tw[name] = hf.typ
+		}
+	}
+
+	return tw
+}
+
+// types returns the types of all host functions regardless of the
+// capabilities they require.
+func (h *Host) types() TypeWorld {
+	tw := make(TypeWorld)
+
+	if h == nil {
+		return tw
+	}
+
+	for name, hf := range h.funcs {
+		tw[name] = hf.typ
+	}
+
+	return tw
+}
+
+// impls returns the implementations of the builtins and of the host
+// functions visible with the capabilities caps.
+func (h *Host) impls(caps Capabilities) map[string]BuiltinFunc {
+	funcs := make(map[string]BuiltinFunc)
+
+	for name, impl := range builtinFuncs {
+		funcs[name] = impl
+	}
+
+	if h == nil {
+		return funcs
+	}
+
+	for name, hf := range h.funcs {
+		if caps.Has(hf.capability) {
+			funcs[name] = hf.impl
+		}
+	}
+
+	return funcs
+}
@@ -0,0 +1,144 @@
+package gocat
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const hostTestCode = `
+func read [] [string] {
+	io.read;
+}
+
+func safe.read [] [string] {
+	'io.read 'test:fallback try;
+}
+
+func fallback [(e error)] [string] {
+	e error.msg;
+}
+
+func greet [] [string] {
+	"hello" log;
+}
+`
+
+func newTestHost(t *testing.T) *Host {
+	host := NewHost()
+
+	err := host.Register("io.read", "io", &FuncType{
+		ArgTypes: []Type{},
+		RetTypes: []Type{&PrimType{Type: "string"}},
+	}, func(rt *Runtime, stack []Value) ([]Value, error) {
+		return nil, fmt.Errorf("Disk on fire.")
+	})
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	err = host.Register("log", "", &FuncType{
+		ArgTypes: []Type{&PrimType{Type: "string"}},
+		RetTypes: []Type{&PrimType{Type: "string"}},
+	}, func(rt *Runtime, stack []Value) ([]Value, error) {
+		return stack, nil
+	})
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	return host
+}
+
+func TestHostRegister(t *testing.T) {
+	host := newTestHost(t)
+	ft := &FuncType{ArgTypes: []Type{}, RetTypes: []Type{}}
+
+	for _, name := range []string{"log", "and", "len", "foo:bar"} {
+		err := host.Register(name, "", ft, nil)
+
+		if err == nil {
+			t.Fatalf("Expected error but got none for %s.", name)
+		}
+	}
+}
+
+func TestCapabilitiesTypeCheck(t *testing.T) {
+	host := newTestHost(t)
+
+	// Without the io capability io.read does not exist.
+	_, err := loadTestModulesHost(map[string]string{"test": hostTestCode}, host, NewCapabilities())
+
+	if err == nil || !strings.Contains(err.Error(), "Function `io.read` does not exist") {
+		t.Fatalf("Expected missing function but got: %v", err)
+	}
+
+	_, err = loadTestModulesHost(map[string]string{"test": hostTestCode}, host, NewCapabilities("net"))
+
+	if err == nil {
+		t.Fatalf("Expected error but got none.")
+	}
+
+	_, err = loadTestModulesHost(map[string]string{"test": hostTestCode}, host, NewCapabilities("io"))
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+}
+
+func TestCapabilitiesRuntime(t *testing.T) {
+	host := newTestHost(t)
+	modules := mustLoadTestModuleHost("test", hostTestCode, host, NewCapabilities("io"), t)
+
+	rt := NewRuntime(modules)
+	rt.SetHost(host, NewCapabilities("io"))
+
+	checkExecRuntimeValues(rt, "test:greet", []Value{"hello"}, t)
+
+	// Failing host functions can be caught.
+	checkExecRuntimeValues(rt, "test:safe.read", []Value{"Disk on fire."}, t)
+
+	_, err := rt.Exec("test:read", nil)
+
+	if _, ok := err.(*RuntimeError); !ok {
+		t.Fatalf("Expected runtime error but got: %v", err)
+	}
+
+	// The same code run without the capability fails.
+	rt.SetHost(host, NewCapabilities())
+
+	checkExecRuntimeValues(rt, "test:greet", []Value{"hello"}, t)
+
+	_, err = rt.Exec("test:read", nil)
+
+	re, ok := err.(*RuntimeError)
+
+	if !ok || re.Msg != "Function `io.read` is not available." {
+		t.Fatalf("Expected runtime error but got: %v", err)
+	}
+
+	checkExecRuntimeValues(rt, "test:safe.read", []Value{"Function `io.read` is not available."}, t)
+}
+
+func checkExecRuntimeValues(rt *Runtime, fqname string, exp []Value, t *testing.T) {
+	rets, err := rt.Exec(fqname, nil)
+
+	if err != nil {
+		t.Fatalf("Unexpected error for %s: %s", fqname, err.Error())
+		return
+	}
+
+	if len(rets) != len(exp) {
+		t.Fatalf("Expected %v but got %v for %s.", exp, rets, fqname)
+		return
+	}
+
+	for i := 0; i < len(rets); i++ {
+		if rets[i] != exp[i] {
+			t.Fatalf("Expected %v but got %v for %s.", exp, rets, fqname)
+			return
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package gocat
+
+import (
+	"fmt"
+)
+
+// Warning is a problem found by the type checker that does not prevent
+// the code from running.
+type Warning struct {
+	Pos *FilePos
+	Msg string
+}
+
+func (w *Warning) String() string {
+	return fmt.Sprintf("Warning %s: %s", w.Pos, w.Msg)
+}
+
+// checkImports checks that the imported modules and names exist and
+// sets the warnings of the modules about unused imports.
+func checkImports(modules map[string]*Module) error {
+	for _, name := range sortedNames(modules) {
+		module := modules[name]
+		module.Warnings = nil
+
+		for _, imp := range module.Imports {
+			other := modules[imp.Module]
+
+			if other == nil {
+				return fmt.Errorf("Module `%s` imported %s is not loaded.", imp.Module, imp.Token.Pos)
+			}
+
+			for _, iname := range imp.Names {
+				if other.Funcs[iname] == nil && other.Types[iname] == nil {
+					return fmt.Errorf("`%s` imported %s does not exist in module `%s`.", iname, imp.Token.Pos, imp.Module)
+				}
+
+				if module.Name != other.Name && module.Funcs[iname] != nil {
+					return fmt.Errorf("`%s` imported %s clashes with `%s:%s`.", iname, imp.Token.Pos, module.Name, iname)
+				}
+			}
+
+			if !imp.used {
+				module.Warnings = append(module.Warnings, &Warning{
+					Pos: imp.Token.Pos,
+					Msg: fmt.Sprintf("Module `%s` is imported but not used.", imp.Module),
+				})
+
+				continue
+			}
+
+			for _, iname := range imp.Names {
+				if !imp.usedNames[iname] {
+					module.Warnings = append(module.Warnings, &Warning{
+						Pos: imp.Token.Pos,
+						Msg: fmt.Sprintf("`%s` is imported from module `%s` but not used.", iname, imp.Module),
+					})
+				}
+			}
+		}
+	}
+
+	return nil
+}
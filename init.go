@@ -0,0 +1,130 @@
+package gocat
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// InitFunc is the name of the functions run by Runtime.Init.
+const InitFunc = "init"
+
+// checkInits checks that the `init` functions of the modules have the
+// type `func{ : }`.
+func checkInits(modules map[string]*Module) error {
+	for _, name := range sortedNames(modules) {
+		fn := modules[name].Funcs[InitFunc]
+
+		if fn == nil {
+			continue
+		}
+
+		if fn.FuncNode == nil || len(fn.Type.ArgTypes) != 0 || len(fn.Type.RetTypes) != 0 {
+			return fmt.Errorf("`%s:%s` must be a function of type `func{ : }` but is of type `%s`.",
+				name, InitFunc, fn.Type)
+		}
+	}
+
+	return nil
+}
+
+// moduleDeps returns the names of the modules the module depends on
+// because it references them in code, imports or requires them.
+func moduleDeps(module *Module, modules map[string]*Module) []string {
+	set := make(map[string]bool)
+
+	for _, fn := range module.Funcs {
+		if fn.FuncNode == nil {
+			continue
+		}
+
+		walkRefs(fn.FuncNode.Body, func(name string, tk *Token) error {
+			if i := strings.LastIndex(name, ":"); i >= 0 {
+				set[name[:i]] = true
+			}
+
+			return nil
+		})
+	}
+
+	for _, imp := range module.Imports {
+		set[imp.Module] = true
+	}
+
+	if module.Manifest != nil {
+		for _, req := range module.Manifest.Requires {
+			set[req.Module] = true
+		}
+	}
+
+	delete(set, module.Name)
+
+	for name := range set {
+		if modules[name] == nil {
+			delete(set, name)
+		}
+	}
+
+	return sortedNames(set)
+}
+
+// initOrder returns the names of the modules ordered such that modules
+// come after the modules they depend on. Modules depending on each other
+// are ordered by name.
+func initOrder(modules map[string]*Module) []string {
+	order := make([]string, 0, len(modules))
+	visited := make(map[string]bool)
+
+	var visit func(name string)
+
+	visit = func(name string) {
+		if visited[name] {
+			return
+		}
+
+		visited[name] = true
+
+		for _, dep := range moduleDeps(modules[name], modules) {
+			visit(dep)
+		}
+
+		order = append(order, name)
+	}
+
+	for _, name := range sortedNames(modules) {
+		visit(name)
+	}
+
+	return order
+}
+
+// Init runs the `init` functions of the modules. A module is initialised
+// after the modules it depends on.
+func (rt *Runtime) Init() error {
+	return rt.InitContext(context.Background())
+}
+
+// InitContext is like Init but aborts with the error of the context when
+// the context is done.
+func (rt *Runtime) InitContext(ctx context.Context) error {
+	for _, name := range initOrder(rt.modules) {
+		module := rt.modules[name]
+		fn := module.Funcs[InitFunc]
+
+		if fn == nil || fn.FuncNode == nil {
+			continue
+		}
+
+		_, err := rt.ExecContext(ctx, name+":"+InitFunc, nil)
+
+		if err != nil {
+			return &LoadModuleError{
+				ModulePath: module.Path,
+				FilePath:   fn.FuncNode.Token.Pos.FilePath,
+				Msg:        fmt.Sprintf("Initialising module `%s` failed: %s", name, err.Error()),
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,110 @@
+package gocat
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInit(t *testing.T) {
+	var trace []string
+
+	host := NewHost()
+
+	err := host.Register("trace", "", &FuncType{
+		ArgTypes: []Type{&PrimType{Type: "string"}},
+		RetTypes: []Type{},
+	}, func(rt *Runtime, stack []Value) ([]Value, error) {
+		trace = append(trace, stack[len(stack)-1].(string))
+		return stack[:len(stack)-1], nil
+	})
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	codes := map[string]string{
+		"app": `
+import lib
+
+func init [] [] {
+	"app" trace;
+}
+`,
+		"lib": `
+pub func name [] [string] {
+	"lib";
+}
+
+func init [] [] {
+	name trace;
+}
+`,
+		"aaa": `
+func init [] [] {
+	app:main;
+}
+`,
+		"none": `
+func f [] [] {
+}
+`,
+	}
+
+	codes["app"] += `
+pub func main [] [] {
+	"main" trace;
+}
+
+func f [] [] {
+	lib:name trace;
+}
+`
+
+	modules, err := loadTestModulesHost(codes, host, nil)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	rt := NewRuntime(modules)
+	rt.SetHost(host, nil)
+
+	err = rt.Init()
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	if strings.Join(trace, " ") != "lib app main" {
+		t.Fatalf("Unexpected init order: %v", trace)
+	}
+
+	badTypes := []string{
+		"func init [] [int] { 1; }",
+		"func init [(x int)] [] { }",
+		"const init 1",
+		"type init { x: int }",
+	}
+
+	for _, code := range badTypes {
+		_, err := loadTestModules(map[string]string{"test": code})
+
+		if err == nil || !strings.Contains(err.Error(), "`test:init` must be a function of type `func{ : }`") {
+			t.Fatalf("Expected an error for %s but got: %v", code, err)
+		}
+	}
+
+	modules, err = loadTestModules(map[string]string{"test": "func init [] [] { 1 0 div.i sink; } func sink [(x int)] [] { }"})
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	err = NewRuntime(modules).Init()
+
+	lme, ok := err.(*LoadModuleError)
+
+	if !ok || !strings.Contains(lme.Msg, "Initialising module `test` failed") {
+		t.Fatalf("Expected a load module error but got: %v", err)
+	}
+}
@@ -0,0 +1,1081 @@
+package gocat
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Value is a value at runtime. Ints are int64, floats are float64,
+// bools are bool, strings are string, lists are *ListValue, maps are
+// *MapValue, records are *RecordValue, errors are *ErrorValue and
+// quotations are *QuotValue.
+type Value interface{}
+
+type ListValue struct {
+	Type  *ListType
+	Elems []Value
+}
+
+type MapValue struct {
+	Type    *MapType
+	Entries map[Value]Value
+}
+
+// RecordValue is the runtime value of a record.
+type RecordValue struct {
+	Type   *RecordType
+	Fields []Value
+}
+
+// ErrorValue is the value of a runtime error caught by `try`.
+type ErrorValue struct {
+	Msg string
+	Pos *FilePos
+}
+
+type QuotValue struct {
+	Name string
+	Type *FuncType
+
+	// Quotations of arguments carry the value of the argument
+	// because arguments only exist within their function.
+	isArg bool
+	arg   Value
+}
+
+type RuntimeError struct {
+	Pos *FilePos
+	Msg string
+
+	// Frames is the gocat call stack at the point of the error with
+	// the innermost frame first.
+	Frames []Frame
+}
+
+// Frame is a frame of the gocat call stack.
+type Frame struct {
+	// Func is the fully qualified name of the function.
+	Func string
+
+	// Pos is the position of the verb that was being executed.
+	Pos *FilePos
+}
+
+func (re *RuntimeError) Error() string {
+	var msg string
+
+	if re.Pos == nil {
+		msg = fmt.Sprintf("Runtime error: %s", re.Msg)
+	} else {
+		msg = fmt.Sprintf("Runtime error %s: %s", re.Pos, re.Msg)
+	}
+
+	if len(re.Frames) == 0 {
+		return msg
+	}
+
+	return msg + "\n\n" + re.StackTrace()
+}
+
+// StackTrace formats the frames of the error like the stack trace
+// of a panic in Go.
+func (re *RuntimeError) StackTrace() string {
+	var sb strings.Builder
+
+	sb.WriteString("gocat stack:\n")
+
+	for _, fr := range re.Frames {
+		sb.WriteString(fr.Func)
+		sb.WriteString("(...)\n")
+
+		if fr.Pos == nil {
+			sb.WriteString("\t<unknown>\n")
+		} else {
+			fmt.Fprintf(&sb, "\t%s:%d:%d\n", fr.Pos.FilePath, fr.Pos.LineNumber, fr.Pos.CharNumber)
+		}
+	}
+
+	return sb.String()
+}
+
+// A BuiltinFunc implements a builtin. It pops its arguments from the
+// stack and pushes its return values.
+type BuiltinFunc func(rt *Runtime, stack []Value) ([]Value, error)
+
+// Runtime executes type checked modules. A Runtime must not be used
+// by multiple goroutines at the same time.
+type Runtime struct {
+	modules   map[string]*Module
+	builtins  map[string]BuiltinFunc
+	hostTypes TypeWorld
+	frames    []*frame
+
+	limits  Limits
+	ctx     context.Context
+	steps   uint64
+	alloced uint64
+
+	cover *Coverage
+	prof  *Profiler
+	debug *Debugger
+}
+
+type frame struct {
+	fqname string
+	fn     *Func
+	args   map[string]Value
+
+	// pos is the position of the verb currently being executed.
+	pos *FilePos
+
+	// base is the number of values on the value stacks of the
+	// callers of the frame.
+	base uint64
+}
+
+func NewRuntime(modules map[string]*Module) *Runtime {
+	return &Runtime{
+		modules:   modules,
+		builtins:  builtinFuncs,
+		hostTypes: make(TypeWorld),
+		ctx:       context.Background(),
+	}
+}
+
+// SetHost makes the host functions of host visible with the capabilities
+// caps available to subsequent executions. Calls to host functions that
+// are not visible fail with a runtime error.
+func (rt *Runtime) SetHost(host *Host, caps Capabilities) {
+	rt.builtins = host.impls(caps)
+	rt.hostTypes = host.types()
+}
+
+// SetLimits sets the limits for subsequent executions.
+func (rt *Runtime) SetLimits(limits Limits) {
+	rt.limits = limits
+}
+
+var builtinFuncs map[string]BuiltinFunc = map[string]BuiltinFunc{
+	"square.i": func(rt *Runtime, stack []Value) ([]Value, error) {
+		a := stack[len(stack)-1].(int64)
+		return append(stack[:len(stack)-1], a*a), nil
+	},
+	"and": func(rt *Runtime, stack []Value) ([]Value, error) {
+		a := stack[len(stack)-2].(bool)
+		b := stack[len(stack)-1].(bool)
+		return append(stack[:len(stack)-2], a && b), nil
+	},
+	"or": func(rt *Runtime, stack []Value) ([]Value, error) {
+		a := stack[len(stack)-2].(bool)
+		b := stack[len(stack)-1].(bool)
+		return append(stack[:len(stack)-2], a || b), nil
+	},
+	"not": func(rt *Runtime, stack []Value) ([]Value, error) {
+		a := stack[len(stack)-1].(bool)
+		return append(stack[:len(stack)-1], !a), nil
+	},
+	"div.i": func(rt *Runtime, stack []Value) ([]Value, error) {
+		a := stack[len(stack)-2].(int64)
+		b := stack[len(stack)-1].(int64)
+
+		if b == 0 {
+			return nil, fmt.Errorf("Division by zero.")
+		}
+
+		return append(stack[:len(stack)-2], a/b), nil
+	},
+	"fail": func(rt *Runtime, stack []Value) ([]Value, error) {
+		return nil, fmt.Errorf("%s", stack[len(stack)-1].(string))
+	},
+	"error.msg": func(rt *Runtime, stack []Value) ([]Value, error) {
+		ev := stack[len(stack)-1].(*ErrorValue)
+		return append(stack[:len(stack)-1], ev.Msg), nil
+	},
+	"error.pos": func(rt *Runtime, stack []Value) ([]Value, error) {
+		ev := stack[len(stack)-1].(*ErrorValue)
+		pos := "<unknown>"
+
+		if ev.Pos != nil {
+			pos = ev.Pos.String()
+
+			err := rt.AllocString(pos)
+
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return append(stack[:len(stack)-1], pos), nil
+	},
+	"assert.true": func(rt *Runtime, stack []Value) ([]Value, error) {
+		if !stack[len(stack)-1].(bool) {
+			return nil, fmt.Errorf("Assertion failed.")
+		}
+
+		return stack[:len(stack)-1], nil
+	},
+	"try":       runTry,
+	"len":       runLen,
+	"get":       runGet,
+	"put":       runPut,
+	"append":    runAppend,
+	"each":      runEach,
+	"assert.eq": runAssertEq,
+}
+
+// lookupFunc looks up a function by its fully qualified name.
+func (rt *Runtime) lookupFunc(fqname string) *Func {
+	i := strings.LastIndex(fqname, ":")
+
+	if i < 0 {
+		return nil
+	}
+
+	module := rt.modules[fqname[:i]]
+
+	if module == nil {
+		return nil
+	}
+
+	return module.Funcs[fqname[i+1:]]
+}
+
+// Exec calls the function with the fully qualified name fqname with
+// the arguments args and returns the values it returned.
+func (rt *Runtime) Exec(fqname string, args []Value) ([]Value, error) {
+	return rt.ExecContext(context.Background(), fqname, args)
+}
+
+// ExecContext is like Exec but aborts the execution with the error of
+// the context when the context is done. The limits of the runtime apply
+// to each execution separately.
+func (rt *Runtime) ExecContext(ctx context.Context, fqname string, args []Value) ([]Value, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	rt.ctx = ctx
+	rt.steps = 0
+	rt.alloced = 0
+
+	defer func() {
+		rt.ctx = context.Background()
+
+		if rt.prof != nil {
+			rt.prof.stop()
+		}
+	}()
+
+	fn := rt.lookupFunc(fqname)
+
+	if fn == nil {
+		return nil, fmt.Errorf("Function `%s` does not exist!", fqname)
+	}
+
+	if len(args) != len(fn.Type.ArgTypes) {
+		return nil, fmt.Errorf("Function `%s` takes %d arguments but got %d.",
+			fqname, len(fn.Type.ArgTypes), len(args))
+	}
+
+	stack := append(make([]Value, 0, len(args)), args...)
+
+	return rt.callFunc(fqname, fn, stack)
+}
+
+// Context returns the context of the current execution. It is
+// context.Background() outside of executions.
+func (rt *Runtime) Context() context.Context {
+	return rt.ctx
+}
+
+func (rt *Runtime) callFunc(fqname string, fn *Func, stack []Value) ([]Value, error) {
+	if fn.Native != nil {
+		return fn.Native(rt, stack)
+	}
+
+	m := len(fn.FuncNode.Args)
+
+	if len(stack) < m {
+		panic("BUG: not enough arguments?")
+	}
+
+	fr := &frame{
+		fqname: fqname,
+		fn:     fn,
+		args:   make(map[string]Value),
+		base:   uint64(len(stack) - m),
+	}
+
+	if len(rt.frames) > 0 {
+		fr.base += rt.frames[len(rt.frames)-1].base
+	}
+
+	// The arguments are popped from the stack into the frame.
+	for i, arg := range fn.FuncNode.Args {
+		fr.args[arg.Name] = stack[len(stack)-m+i]
+	}
+
+	stack = stack[:len(stack)-m]
+
+	rt.frames = append(rt.frames, fr)
+
+	err := rt.checkCallDepth()
+
+	if err != nil {
+		rt.frames = rt.frames[:len(rt.frames)-1]
+		return nil, err
+	}
+
+	rets, err := rt.evalBlock(fr, fn.FuncNode.Body, nil)
+	rt.frames = rt.frames[:len(rt.frames)-1]
+
+	if err != nil {
+		return nil, err
+	}
+
+	return append(stack, rets...), nil
+}
+
+// CallQuot calls a quotation with its arguments on the stack.
+func (rt *Runtime) CallQuot(quot *QuotValue, stack []Value) ([]Value, error) {
+	if quot.isArg {
+		return append(stack, quot.arg), nil
+	}
+
+	return rt.callVerb(nil, quot.Name, nil, stack)
+}
+
+func (rt *Runtime) evalBlock(fr *frame, nodes []Node, stack []Value) ([]Value, error) {
+	var err error
+
+	for _, node := range nodes {
+		stack, err = rt.evalNode(fr, node, stack)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return stack, nil
+}
+
+func (rt *Runtime) evalNode(fr *frame, node Node, stack []Value) ([]Value, error) {
+	var err error
+
+	switch node.(type) {
+	case *ExpNode:
+		if rt.cover != nil {
+			rt.cover.count(node, 0)
+		}
+
+		for _, v := range node.(*ExpNode).Exps {
+			if rt.debug != nil && fr != nil {
+				err = rt.debug.check(rt, fr, v, stack)
+
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			stack, err = rt.evalData(fr, v, stack)
+
+			if err != nil {
+				return nil, err
+			}
+
+			err = rt.checkStack(fr, stack)
+
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return stack, nil
+
+	case *IfElseNode:
+		ifn := node.(*IfElseNode)
+
+		err = rt.step(fr)
+
+		if err != nil {
+			return nil, err
+		}
+
+		stack, err = rt.evalNode(fr, ifn.Condition, stack)
+
+		if err != nil {
+			return nil, err
+		}
+
+		cond := stack[len(stack)-1].(bool)
+		stack = stack[:len(stack)-1]
+
+		if cond {
+			if rt.cover != nil {
+				rt.cover.count(node, 0)
+			}
+
+			return rt.evalBlock(fr, ifn.ThenBlock, stack)
+		} else {
+			if rt.cover != nil {
+				rt.cover.count(node, 1)
+			}
+
+			return rt.evalBlock(fr, ifn.ElseBlock, stack)
+		}
+
+	case *MatchNode:
+		mn := node.(*MatchNode)
+		top := stack[len(stack)-1]
+
+		err = rt.step(fr)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for i, mc := range mn.Cases {
+			if valueHasType(top, mc.Type) {
+				if rt.cover != nil {
+					rt.cover.count(node, i)
+				}
+
+				return rt.evalBlock(fr, mc.Body, stack)
+			}
+		}
+
+		panic("BUG: no case matched?")
+
+	case *LitIntNode, *LitFloatNode, *LitBoolNode, *LitStringNode, *LitListNode, *LitMapNode:
+		return rt.evalData(fr, node, stack)
+	}
+
+	panic("BUG: can't evaluate node?")
+}
+
+func (rt *Runtime) evalData(fr *frame, v Node, stack []Value) ([]Value, error) {
+	// The position is set before the step so that the step is
+	// attributed to the verb.
+	if verb, ok := v.(*VerbNode); ok && fr != nil {
+		fr.pos = verb.Token.Pos
+	}
+
+	err := rt.step(fr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch v.(type) {
+	case *LitIntNode:
+		return append(stack, v.(*LitIntNode).Value), nil
+	case *LitFloatNode:
+		return append(stack, v.(*LitFloatNode).Value), nil
+	case *LitBoolNode:
+		return append(stack, v.(*LitBoolNode).Value), nil
+	case *LitStringNode:
+		return append(stack, v.(*LitStringNode).Value), nil
+
+	case *LitListNode:
+		lit := v.(*LitListNode)
+
+		err = rt.Alloc(listSize + valueSize*len(lit.Elems))
+
+		if err != nil {
+			return nil, err
+		}
+
+		elems := make([]Value, 0, len(lit.Elems))
+
+		for _, elem := range lit.Elems {
+			vals, err := rt.evalData(fr, elem, nil)
+
+			if err != nil {
+				return nil, err
+			}
+
+			elems = append(elems, vals[0])
+		}
+
+		return append(stack, &ListValue{
+			Type:  lit.Type,
+			Elems: elems,
+		}), nil
+
+	case *LitMapNode:
+		lit := v.(*LitMapNode)
+
+		err = rt.Alloc(mapSize + entrySize*len(lit.Keys))
+
+		if err != nil {
+			return nil, err
+		}
+
+		entries := make(map[Value]Value)
+
+		for i := 0; i < len(lit.Keys); i++ {
+			keys, err := rt.evalData(fr, lit.Keys[i], nil)
+
+			if err != nil {
+				return nil, err
+			}
+
+			vals, err := rt.evalData(fr, lit.Values[i], nil)
+
+			if err != nil {
+				return nil, err
+			}
+
+			entries[keys[0]] = vals[0]
+		}
+
+		return append(stack, &MapValue{
+			Type:    lit.Type,
+			Entries: entries,
+		}), nil
+
+	case *QuotNode:
+		quot := v.(*QuotNode)
+
+		if fr != nil {
+			for _, arg := range fr.fn.FuncNode.Args {
+				if arg.Name == quot.Ident {
+					return append(stack, &QuotValue{
+						Name: quot.Ident,
+						Type: &FuncType{
+							ArgTypes: []Type{},
+							RetTypes: []Type{arg.Type},
+						},
+						isArg: true,
+						arg:   fr.args[arg.Name],
+					}), nil
+				}
+			}
+		}
+
+		fqname := rt.resolveName(fr, quot.Ident)
+
+		return append(stack, &QuotValue{
+			Name: fqname,
+			Type: rt.funcType(fqname),
+		}), nil
+
+	case *VerbNode:
+		verb := v.(*VerbNode)
+
+		return rt.callVerb(fr, verb.Verb, verb.Token, stack)
+	}
+
+	panic("BUG: can't evaluate data?")
+}
+
+// funcType returns the type of a (non generic) function.
+func (rt *Runtime) funcType(name string) *FuncType {
+	if fn := rt.lookupFunc(name); fn != nil {
+		return fn.Type
+	}
+
+	if ft, ok := builtins[name].(*FuncType); ok {
+		return ft
+	}
+
+	if ft, ok := rt.hostTypes[name].(*FuncType); ok {
+		return ft
+	}
+
+	panic("BUG: function does not exist?")
+}
+
+// resolveName returns the fully qualified name of the function name
+// referenced by code executing in fr. Unqualified names refer to the
+// functions of the module of fr if it has such a function.
+func (rt *Runtime) resolveName(fr *frame, name string) string {
+	if fr == nil || strings.ContainsRune(name, ':') {
+		return name
+	}
+
+	mname := fr.fqname[:strings.LastIndex(fr.fqname, ":")]
+	module := rt.modules[mname]
+
+	if module != nil && module.Funcs[name] != nil {
+		return mname + ":" + name
+	}
+
+	return name
+}
+
+// callVerb calls a verb. Arguments of the function executing in fr
+// take precedence over functions of modules which take precedence
+// over builtins. Unqualified verbs may call functions of the module
+// of fr.
+func (rt *Runtime) callVerb(fr *frame, verb string, tk *Token, stack []Value) ([]Value, error) {
+	if fr != nil {
+		if arg, ok := fr.args[verb]; ok {
+			return append(stack, arg), nil
+		}
+	}
+
+	fqname := rt.resolveName(fr, verb)
+
+	if fn := rt.lookupFunc(fqname); fn != nil {
+		if fn.Const != nil {
+			return append(stack, fn.Const), nil
+		}
+
+		return rt.callFunc(fqname, fn, stack)
+	}
+
+	builtin := rt.builtins[verb]
+
+	// Host functions may not be visible at runtime if the code was
+	// type checked with different capabilities.
+	if builtin == nil {
+		re := &RuntimeError{
+			Msg:    fmt.Sprintf("Function `%s` is not available.", verb),
+			Frames: rt.stackTrace(),
+		}
+
+		if tk != nil {
+			re.Pos = tk.Pos
+		}
+
+		return nil, re
+	}
+
+	stack, err := builtin(rt, stack)
+
+	if err != nil {
+		if _, ok := err.(*RuntimeError); ok {
+			return nil, err
+		}
+
+		if lee, ok := err.(*LimitExceededError); ok && lee.Pos == nil && tk != nil {
+			lee.Pos = tk.Pos
+		}
+
+		if isFatal(err) {
+			return nil, err
+		}
+
+		re := &RuntimeError{
+			Msg:    err.Error(),
+			Frames: rt.stackTrace(),
+		}
+
+		if tk != nil {
+			re.Pos = tk.Pos
+		}
+
+		return nil, re
+	}
+
+	return stack, nil
+}
+
+// stackTrace returns the frames of the gocat call stack with the
+// innermost frame first.
+func (rt *Runtime) stackTrace() []Frame {
+	frames := make([]Frame, 0, len(rt.frames))
+
+	for i := len(rt.frames) - 1; i >= 0; i-- {
+		frames = append(frames, Frame{
+			Func: rt.frames[i].fqname,
+			Pos:  rt.frames[i].pos,
+		})
+	}
+
+	return frames
+}
+
+// typeOf returns the runtime type of a value.
+func typeOf(v Value) Type {
+	switch v.(type) {
+	case int64:
+		return &PrimType{Type: "int"}
+	case float64:
+		return &PrimType{Type: "float"}
+	case bool:
+		return &PrimType{Type: "bool"}
+	case string:
+		return &PrimType{Type: "string"}
+	case *ErrorValue:
+		return &PrimType{Type: "error"}
+	case *ListValue:
+		return v.(*ListValue).Type
+	case *MapValue:
+		return v.(*MapValue).Type
+	case *QuotValue:
+		return v.(*QuotValue).Type
+	case *RecordValue:
+		return v.(*RecordValue).Type
+	}
+
+	panic("BUG: unknown value?")
+}
+
+// valueHasType returns true if the runtime type of v is typ or
+// is a member of typ if typ is a union type.
+func valueHasType(v Value, typ Type) bool {
+	if ut, ok := typ.(*UnionType); ok {
+		for _, member := range ut.Types {
+			if valueHasType(v, member) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return TypeEqual(typeOf(v), typ)
+}
+
+// lessValue orders keys of maps.
+func lessValue(a Value, b Value) bool {
+	switch a.(type) {
+	case int64:
+		return a.(int64) < b.(int64)
+	case float64:
+		return a.(float64) < b.(float64)
+	case bool:
+		return !a.(bool) && b.(bool)
+	case string:
+		return a.(string) < b.(string)
+	}
+
+	panic("BUG: can't order values?")
+}
+
+// equalValue returns true if a and b are equal. Values of collections
+// and records are compared element by element.
+func equalValue(a Value, b Value) bool {
+	switch a.(type) {
+	case *ListValue:
+		la, lb := a.(*ListValue), b.(*ListValue)
+
+		if len(la.Elems) != len(lb.Elems) {
+			return false
+		}
+
+		for i := range la.Elems {
+			if !equalValue(la.Elems[i], lb.Elems[i]) {
+				return false
+			}
+		}
+
+		return true
+	case *MapValue:
+		ma, mb := a.(*MapValue), b.(*MapValue)
+
+		if len(ma.Entries) != len(mb.Entries) {
+			return false
+		}
+
+		for k, va := range ma.Entries {
+			vb, ok := mb.Entries[k]
+
+			if !ok || !equalValue(va, vb) {
+				return false
+			}
+		}
+
+		return true
+	case *RecordValue:
+		ra, rb := a.(*RecordValue), b.(*RecordValue)
+
+		if !TypeEqual(ra.Type, rb.Type) {
+			return false
+		}
+
+		for i := range ra.Fields {
+			if !equalValue(ra.Fields[i], rb.Fields[i]) {
+				return false
+			}
+		}
+
+		return true
+	case *ErrorValue:
+		eb, ok := b.(*ErrorValue)
+		return ok && a.(*ErrorValue).Msg == eb.Msg
+	case *QuotValue:
+		qa := a.(*QuotValue)
+		qb, ok := b.(*QuotValue)
+
+		if !ok || qa.isArg != qb.isArg {
+			return false
+		}
+
+		if qa.isArg {
+			return equalValue(qa.arg, qb.arg)
+		}
+
+		return qa.Name == qb.Name
+	}
+
+	// Values of a union type may be of different types.
+	if !TypeEqual(typeOf(a), typeOf(b)) {
+		return false
+	}
+
+	return a == b
+}
+
+// FormatValue formats a value like a literal.
+func FormatValue(v Value) string {
+	switch v := v.(type) {
+	case float64:
+		s := strconv.FormatFloat(v, 'f', -1, 64)
+
+		if !strings.ContainsRune(s, '.') {
+			s += ".0"
+		}
+
+		return s
+	case string:
+		return strconv.Quote(v)
+	case *ListValue:
+		elems := make([]string, len(v.Elems))
+
+		for i, elem := range v.Elems {
+			elems[i] = FormatValue(elem)
+		}
+
+		return v.Type.String() + "[" + strings.Join(elems, " ") + "]"
+	case *MapValue:
+		entries := make([]string, 0, 2*len(v.Entries))
+
+		for _, k := range v.sortedKeys() {
+			entries = append(entries, FormatValue(k), FormatValue(v.Entries[k]))
+		}
+
+		return v.Type.String() + "[" + strings.Join(entries, " ") + "]"
+	case *RecordValue:
+		fields := make([]string, len(v.Fields))
+
+		for i, field := range v.Fields {
+			fields[i] = v.Type.Fields[i].Name + ": " + FormatValue(field)
+		}
+
+		return v.Type.Name + "{" + strings.Join(fields, ", ") + "}"
+	case *ErrorValue:
+		return "error(" + strconv.Quote(v.Msg) + ")"
+	case *QuotValue:
+		if v.isArg {
+			return "'" + FormatValue(v.arg)
+		}
+
+		return "'" + v.Name
+	}
+
+	return fmt.Sprintf("%v", v)
+}
+
+// sortedKeys returns the keys of a map in order.
+func (mv *MapValue) sortedKeys() []Value {
+	keys := make([]Value, 0, len(mv.Entries))
+
+	for k := range mv.Entries {
+		keys = append(keys, k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return lessValue(keys[i], keys[j])
+	})
+
+	return keys
+}
+
+func runLen(rt *Runtime, stack []Value) ([]Value, error) {
+	var n int
+
+	switch coll := stack[len(stack)-1].(type) {
+	case *ListValue:
+		n = len(coll.Elems)
+	case *MapValue:
+		n = len(coll.Entries)
+	}
+
+	return append(stack[:len(stack)-1], int64(n)), nil
+}
+
+func runGet(rt *Runtime, stack []Value) ([]Value, error) {
+	key := stack[len(stack)-1]
+	coll := stack[len(stack)-2]
+	stack = stack[:len(stack)-2]
+
+	switch coll.(type) {
+	case *ListValue:
+		lv := coll.(*ListValue)
+		i := key.(int64)
+
+		if i < 0 || i >= int64(len(lv.Elems)) {
+			return nil, fmt.Errorf("Index %d out of range for list of length %d.", i, len(lv.Elems))
+		}
+
+		return append(stack, lv.Elems[i]), nil
+	case *MapValue:
+		val, ok := coll.(*MapValue).Entries[key]
+
+		if !ok {
+			return nil, fmt.Errorf("Key %v does not exist in map.", key)
+		}
+
+		return append(stack, val), nil
+	}
+
+	panic("BUG: not a collection?")
+}
+
+// runPut returns a copy of the collection with the value put at the
+// index or key. Collections are never modified in place.
+func runPut(rt *Runtime, stack []Value) ([]Value, error) {
+	val := stack[len(stack)-1]
+	key := stack[len(stack)-2]
+	coll := stack[len(stack)-3]
+	stack = stack[:len(stack)-3]
+
+	switch coll.(type) {
+	case *ListValue:
+		lv := coll.(*ListValue)
+		i := key.(int64)
+
+		if i < 0 || i >= int64(len(lv.Elems)) {
+			return nil, fmt.Errorf("Index %d out of range for list of length %d.", i, len(lv.Elems))
+		}
+
+		err := rt.Alloc(listSize + valueSize*len(lv.Elems))
+
+		if err != nil {
+			return nil, err
+		}
+
+		elems := append(make([]Value, 0, len(lv.Elems)), lv.Elems...)
+		elems[i] = val
+
+		return append(stack, &ListValue{
+			Type:  lv.Type,
+			Elems: elems,
+		}), nil
+	case *MapValue:
+		mv := coll.(*MapValue)
+
+		err := rt.Alloc(mapSize + entrySize*(len(mv.Entries)+1))
+
+		if err != nil {
+			return nil, err
+		}
+
+		entries := make(map[Value]Value, len(mv.Entries)+1)
+
+		for k, v := range mv.Entries {
+			entries[k] = v
+		}
+
+		entries[key] = val
+
+		return append(stack, &MapValue{
+			Type:    mv.Type,
+			Entries: entries,
+		}), nil
+	}
+
+	panic("BUG: not a collection?")
+}
+
+func runAppend(rt *Runtime, stack []Value) ([]Value, error) {
+	val := stack[len(stack)-1]
+	lv := stack[len(stack)-2].(*ListValue)
+	stack = stack[:len(stack)-2]
+
+	err := rt.Alloc(listSize + valueSize*(len(lv.Elems)+1))
+
+	if err != nil {
+		return nil, err
+	}
+
+	elems := append(make([]Value, 0, len(lv.Elems)+1), lv.Elems...)
+
+	return append(stack, &ListValue{
+		Type:  lv.Type,
+		Elems: append(elems, val),
+	}), nil
+}
+
+func runEach(rt *Runtime, stack []Value) ([]Value, error) {
+	quot := stack[len(stack)-1].(*QuotValue)
+	coll := stack[len(stack)-2]
+	stack = stack[:len(stack)-2]
+
+	var err error
+
+	switch coll.(type) {
+	case *ListValue:
+		for _, elem := range coll.(*ListValue).Elems {
+			stack, err = rt.CallQuot(quot, append(stack, elem))
+
+			if err != nil {
+				return nil, err
+			}
+		}
+	case *MapValue:
+		mv := coll.(*MapValue)
+
+		for _, key := range mv.sortedKeys() {
+			stack, err = rt.CallQuot(quot, append(stack, key, mv.Entries[key]))
+
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return stack, nil
+}
+
+// runAssertEq fails if the two values on top of the stack are not equal.
+func runAssertEq(rt *Runtime, stack []Value) ([]Value, error) {
+	a := stack[len(stack)-2]
+	b := stack[len(stack)-1]
+
+	if !equalValue(a, b) {
+		return nil, fmt.Errorf("Assertion failed: %s is not equal to %s.", FormatValue(a), FormatValue(b))
+	}
+
+	return stack[:len(stack)-2], nil
+}
+
+// runTry calls the quotation below the handler. If the quotation fails
+// with a runtime error the handler is called with the arguments of the
+// quotation and the error.
+func runTry(rt *Runtime, stack []Value) ([]Value, error) {
+	handler := stack[len(stack)-1].(*QuotValue)
+	quot := stack[len(stack)-2].(*QuotValue)
+	stack = stack[:len(stack)-2]
+
+	// The arguments are saved because the quotation may have consumed
+	// them when it fails.
+	m := len(quot.Type.ArgTypes)
+	args := append(make([]Value, 0, m), stack[len(stack)-m:]...)
+
+	rets, err := rt.CallQuot(quot, stack)
+
+	if err == nil {
+		return rets, nil
+	}
+
+	re, ok := err.(*RuntimeError)
+
+	if !ok {
+		return nil, err
+	}
+
+	stack = append(stack[:len(stack)-m], args...)
+	stack = append(stack, &ErrorValue{
+		Msg: re.Msg,
+		Pos: re.Pos,
+	})
+
+	return rt.CallQuot(handler, stack)
+}
@@ -0,0 +1,594 @@
+package gocat
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExecCollections(t *testing.T) {
+	code := `
+func first [(xs list<int>)] [int] {
+	xs 0 get;
+}
+
+func count [] [int] {
+	list<int>[1 2 3] 4 append len;
+}
+
+func replace [] [int] {
+	list<int>[1 2 3] 1 9 put 1 get;
+}
+
+func lookup [(k int)] [float] {
+	map<int float>[1 1.5 2 2.5] 3 3.5 put k get;
+}
+
+func ignore [(x int)] [] {
+}
+
+func ignorekv [(k int) (v float)] [] {
+}
+
+func iter [] [int] {
+	list<int>[1 2 3] 'test:ignore each;
+	map<int float>[1 1.0] 'test:ignorekv each;
+	5;
+}
+
+func oob [] [int] {
+	list<int>[1 2 3] 3 get;
+}
+
+func failing [] [] {
+	list<int>[1 2 3] 'test:oobarg each;
+}
+
+func oobarg [(i int)] [] {
+	list<int>[1] i i put 'test:ignore each;
+}
+`
+	modules := mustLoadTestModule("test", code, t)
+
+	checkExec(modules, "test:first", []Value{&ListValue{Elems: []Value{int64(7)}}}, []Value{int64(7)}, t)
+	checkExec(modules, "test:count", nil, []Value{int64(4)}, t)
+	checkExec(modules, "test:replace", nil, []Value{int64(9)}, t)
+	checkExec(modules, "test:lookup", []Value{int64(2)}, []Value{2.5}, t)
+	checkExec(modules, "test:lookup", []Value{int64(3)}, []Value{3.5}, t)
+	checkExec(modules, "test:iter", nil, []Value{int64(5)}, t)
+
+	mustErrorExec(modules, "test:lookup", []Value{int64(4)}, t)
+	mustErrorExec(modules, "test:oob", nil, t)
+	mustErrorExec(modules, "test:failing", nil, t)
+	mustErrorExec(modules, "test:doesnotexist", nil, t)
+	mustErrorExec(modules, "test:first", nil, t)
+}
+
+func TestExecMatch(t *testing.T) {
+	code := `
+func tag [(v {int bool list<int>})] [int] {
+	v;
+	match {
+		int { square.i; }
+		bool { if not { 1; } else { 0; } }
+		list<int> { len; }
+	}
+}
+`
+	modules := mustLoadTestModule("test", code, t)
+
+	checkExec(modules, "test:tag", []Value{int64(3)}, []Value{int64(9)}, t)
+	checkExec(modules, "test:tag", []Value{false}, []Value{int64(1)}, t)
+	checkExec(modules, "test:tag", []Value{
+		&ListValue{
+			Type:  &ListType{ElemType: &PrimType{Type: "int"}},
+			Elems: []Value{int64(1), int64(2)},
+		}}, []Value{int64(2)}, t)
+}
+
+func TestExecRecords(t *testing.T) {
+	geo := `
+pub type point { x: int y: int }
+
+pub func origin [] [point] {
+	0 0 geo:point;
+}
+
+func y [(p point)] [int] {
+	p geo:point.y;
+}
+
+func movex [(p geo:point) (x int)] [point] {
+	p x geo:point.x.set;
+}
+`
+	shapes := `
+type circle { center: geo:point radius: int }
+
+func unit [] [circle] {
+	geo:origin 1 shapes:circle;
+}
+
+func centerx [(c circle)] [int] {
+	c shapes:circle.center geo:point.x;
+}
+`
+	modules, err := loadTestModules(map[string]string{"geo": geo, "shapes": shapes})
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+		return
+	}
+
+	rt := NewRuntime(modules)
+	rets, err := rt.Exec("geo:origin", nil)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+		return
+	}
+
+	origin := rets[0].(*RecordValue)
+
+	if origin.Type != modules["geo"].Types["point"] || origin.Fields[0] != int64(0) || origin.Fields[1] != int64(0) {
+		t.Fatalf("Unexpected origin: %+v", origin)
+		return
+	}
+
+	rets, err = rt.Exec("geo:movex", []Value{origin, int64(5)})
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+		return
+	}
+
+	moved := rets[0].(*RecordValue)
+
+	if moved.Fields[0] != int64(5) || origin.Fields[0] != int64(0) {
+		t.Fatalf("Unexpected moved point: %+v (origin: %+v)", moved, origin)
+		return
+	}
+
+	checkExec(modules, "shapes:centerx", []Value{&RecordValue{
+		Type:   modules["shapes"].Types["circle"],
+		Fields: []Value{moved, int64(1)},
+	}}, []Value{int64(5)}, t)
+
+	ft := modules["shapes"].Funcs["circle"].Type
+
+	if ft.String() != "func{geo:point int : shapes:circle}" {
+		t.Fatalf("Unexpected type of constructor: %s", ft)
+		return
+	}
+
+	// Records of different modules are different types.
+	_, err = loadTestModules(map[string]string{
+		"a": "type point { x: int } func f [(p point)] [] { }",
+		"b": "type point { x: int } func g [] [] { 1 b:point a:f; }",
+	})
+
+	if err == nil {
+		t.Fatalf("Expected error but got none.")
+		return
+	}
+
+	// Unknown records of other modules.
+	_, err = loadTestModules(map[string]string{
+		"a": "func f [(p b:point)] [] { }",
+	})
+
+	if err == nil {
+		t.Fatalf("Expected error but got none.")
+		return
+	}
+
+	// Constructors clash with functions.
+	_, err = loadTestModules(map[string]string{
+		"a": "type point { x: int } func point.x [] [] { }",
+	})
+
+	if err == nil {
+		t.Fatalf("Expected error but got none.")
+		return
+	}
+}
+
+func TestExecTry(t *testing.T) {
+	code := `
+func half [(a int) (b int)] [int] {
+	a b div.i;
+}
+
+func fallback [(a int) (b int) (e error)] [int] {
+	a;
+}
+
+func safediv [(a int) (b int)] [int] {
+	a b 'test:half 'test:fallback try;
+}
+
+func message [(a int)] [string] {
+	a 'test:failing 'test:msg try;
+}
+
+func failing [(a int)] [string] {
+	"boom" fail;
+}
+
+func msg [(a int) (e error)] [string] {
+	e error.msg;
+}
+
+func pos [(a int)] [string] {
+	a 'test:failing 'test:errpos try;
+}
+
+func errpos [(a int) (e error)] [string] {
+	e error.pos;
+}
+`
+	modules := mustLoadTestModule("test", code, t)
+
+	checkExec(modules, "test:half", []Value{int64(10), int64(2)}, []Value{int64(5)}, t)
+	mustErrorExec(modules, "test:half", []Value{int64(10), int64(0)}, t)
+	checkExec(modules, "test:safediv", []Value{int64(10), int64(2)}, []Value{int64(5)}, t)
+	checkExec(modules, "test:safediv", []Value{int64(10), int64(0)}, []Value{int64(10)}, t)
+	checkExec(modules, "test:message", []Value{int64(1)}, []Value{"boom"}, t)
+	checkExec(modules, "test:pos", []Value{int64(1)}, []Value{(&FilePos{FilePath: "<memory>", LineNumber: 19, CharNumber: 14}).String()}, t)
+
+	rt := NewRuntime(modules)
+	_, err := rt.Exec("test:failing", []Value{int64(1)})
+
+	re, ok := err.(*RuntimeError)
+
+	if !ok || re.Msg != "boom" || re.Pos.LineNumber != 19 {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestExecStackTrace(t *testing.T) {
+	code := `func outer [] [int] {
+	1 2 test:inner;
+}
+
+func inner [(a int) (b int)] [int] {
+	list<int>[a b] 'test:zero each;
+	a;
+}
+
+func zero [(a int)] [] {
+	a 0 div.i test:ignore;
+}
+
+func ignore [(a int)] [] {
+}
+`
+	modules := mustLoadTestModule("test", code, t)
+
+	rt := NewRuntime(modules)
+	_, err := rt.Exec("test:outer", nil)
+
+	re, ok := err.(*RuntimeError)
+
+	if !ok {
+		t.Fatalf("Expected runtime error but got: %v", err)
+		return
+	}
+
+	exp := []Frame{
+		Frame{Func: "test:zero", Pos: &FilePos{FilePath: "<memory>", LineNumber: 11, CharNumber: 12}},
+		Frame{Func: "test:inner", Pos: &FilePos{FilePath: "<memory>", LineNumber: 6, CharNumber: 33}},
+		Frame{Func: "test:outer", Pos: &FilePos{FilePath: "<memory>", LineNumber: 2, CharNumber: 17}},
+	}
+
+	if len(re.Frames) != len(exp) {
+		t.Fatalf("Expected %d frames but got %d: %s", len(exp), len(re.Frames), re.StackTrace())
+		return
+	}
+
+	for i := 0; i < len(exp); i++ {
+		if re.Frames[i].Func != exp[i].Func || *re.Frames[i].Pos != *exp[i].Pos {
+			t.Fatalf("Expected frame %s %s but got %s %s.", exp[i].Func, exp[i].Pos, re.Frames[i].Func, re.Frames[i].Pos)
+			return
+		}
+	}
+
+	trace := "gocat stack:\n" +
+		"test:zero(...)\n\t<memory>:11:12\n" +
+		"test:inner(...)\n\t<memory>:6:33\n" +
+		"test:outer(...)\n\t<memory>:2:17\n"
+
+	if re.StackTrace() != trace {
+		t.Fatalf("Unexpected stack trace: %s", re.StackTrace())
+		return
+	}
+
+	// The call stack is unwound after the error.
+	if len(rt.frames) != 0 {
+		t.Fatalf("Expected no frames but got %d.", len(rt.frames))
+		return
+	}
+}
+
+func mustLoadTestModule(name string, code string, t *testing.T) map[string]*Module {
+	return mustLoadTestModuleHost(name, code, nil, nil, t)
+}
+
+func mustLoadTestModuleHost(name string, code string, host *Host, caps Capabilities, t *testing.T) map[string]*Module {
+	modules, err := loadTestModulesHost(map[string]string{name: code}, host, caps)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	return modules
+}
+
+// loadTestModules loads and type checks modules from code in memory.
+func loadTestModules(codes map[string]string) (map[string]*Module, error) {
+	return loadTestModulesHost(codes, nil, nil)
+}
+
+func loadTestModulesHost(codes map[string]string, host *Host, caps Capabilities) (map[string]*Module, error) {
+	modules := make(map[string]*Module)
+
+	for name, code := range codes {
+		p := NewParser(NewTokenizerString(code))
+		root, err := p.Root()
+
+		if err != nil {
+			return nil, err
+		}
+
+		module := &Module{
+			Name:  name,
+			Path:  "<memory>",
+			Funcs: make(map[string]*Func),
+			Types: make(map[string]*RecordType),
+		}
+
+		err = module.addRoot(root)
+
+		if err != nil {
+			return nil, err
+		}
+
+		resolveModule(module, module.lookupLocalType)
+		modules[name] = module
+	}
+
+	err := TypeCheckWith(modules, host, caps)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return modules, nil
+}
+
+func checkExec(modules map[string]*Module, fqname string, args []Value, exp []Value, t *testing.T) {
+	rt := NewRuntime(modules)
+	rets, err := rt.Exec(fqname, args)
+
+	if err != nil {
+		t.Fatalf("Unexpected error for %s: %s", fqname, err.Error())
+		return
+	}
+
+	if len(rets) != len(exp) {
+		t.Fatalf("Expected %v but got %v for %s.", exp, rets, fqname)
+		return
+	}
+
+	for i := 0; i < len(rets); i++ {
+		if rets[i] != exp[i] {
+			t.Fatalf("Expected %v but got %v for %s.", exp, rets, fqname)
+			return
+		}
+	}
+}
+
+func mustErrorExec(modules map[string]*Module, fqname string, args []Value, t *testing.T) {
+	rt := NewRuntime(modules)
+	rets, err := rt.Exec(fqname, args)
+
+	if err == nil {
+		t.Fatalf("Expected error but got none for %s: %v", fqname, rets)
+		return
+	}
+}
+
+func TestExecUnqualified(t *testing.T) {
+	lib := `
+pub func run [(xs list<int>) (f func{int :})] [] {
+	xs f each;
+}
+`
+	app := `
+func halfsquare [(x int)] [int] {
+	x square.i x div.i 2 helper;
+}
+
+func helper [(a int) (b int)] [int] {
+	a b div.i square.i;
+}
+
+func quoted [(x int)] [int] {
+	list<int>[1 2] 'sink lib:run;
+	x;
+}
+
+func sink [(x int)] [] {
+}
+
+func shadow [(helper int)] [int] {
+	helper;
+}
+
+func not [(b bool)] [bool] {
+	b;
+}
+
+func qualified [] [bool] {
+	true app:not;
+}
+`
+	modules, err := loadTestModules(map[string]string{"lib": lib, "app": app})
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	checkExec(modules, "app:halfsquare", []Value{int64(4)}, []Value{int64(4)}, t)
+	checkExec(modules, "app:quoted", []Value{int64(3)}, []Value{int64(3)}, t)
+	checkExec(modules, "app:shadow", []Value{int64(3)}, []Value{int64(3)}, t)
+	checkExec(modules, "app:qualified", nil, []Value{true}, t)
+
+	codes := []string{
+		"func not [(b bool)] [bool] { b; } func f [] [bool] { true not; }",
+		"func not [(b bool)] [bool] { b; } func f [] [func{bool : bool}] { 'not; }",
+		"func len [] [int] { 0; } func f [] [int] { len; }",
+		"func sink [(x int)] [] { } func f [] [] { list<int>[1] 'sink run; }",
+	}
+
+	for _, code := range codes {
+		_, err := loadTestModules(map[string]string{"lib": lib, "app": code})
+
+		if err == nil {
+			t.Fatalf("Expected an error but got none for: %s", code)
+		}
+	}
+}
+
+func TestExecImports(t *testing.T) {
+	geometry := `
+pub type point { x: int y: int }
+
+pub func origin [] [point] {
+	0 0 point;
+}
+
+pub func unused [] [] {
+}
+`
+	app := `
+import geometry as geo
+import geometry (origin point.x unused)
+
+func x [] [int] {
+	origin point.x;
+}
+
+func y [(p geo:point)] [int] {
+	p geo:point.y;
+}
+`
+	other := `
+import geometry
+import app
+
+func z [] [int] {
+	1;
+}
+`
+	modules, err := loadTestModules(map[string]string{"geometry": geometry, "app": app, "other": other})
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	checkExec(modules, "app:x", nil, []Value{int64(0)}, t)
+
+	warnings := []string{}
+
+	for _, name := range []string{"app", "other"} {
+		for _, warning := range modules[name].Warnings {
+			warnings = append(warnings, warning.Msg)
+		}
+	}
+
+	exp := []string{
+		"`unused` is imported from module `geometry` but not used.",
+		"Module `geometry` is imported but not used.",
+		"Module `app` is imported but not used.",
+	}
+
+	if !reflect.DeepEqual(warnings, exp) {
+		t.Fatalf("Unexpected warnings: %q", warnings)
+	}
+
+	codes := []string{
+		"import missing func f [] [] { }",
+		"import geometry (missing) func f [] [] { }",
+		"import geometry (origin) func f [] [] { } func origin [] [] { }",
+	}
+
+	for _, code := range codes {
+		_, err := loadTestModules(map[string]string{"geometry": geometry, "app": code})
+
+		if err == nil {
+			t.Fatalf("Expected an error but got none for: %s", code)
+		}
+	}
+}
+
+func TestExecConsts(t *testing.T) {
+	lib := `
+pub const answer 42
+const secret "s"
+pub const primes list<int>[2 3 5]
+`
+	app := `
+import lib (answer)
+
+const scale 2
+const names map<int string>[1 "one"]
+
+func scaled [] [int] {
+	answer scale div.i;
+}
+
+func second [] [int] {
+	lib:primes 1 get;
+}
+
+func name [] [string] {
+	names 1 get;
+}
+
+func quoted [] [int] {
+	list<int>[scale] 'sink each;
+	app:scale;
+}
+
+func sink [(x int)] [] {
+}
+`
+	modules, err := loadTestModules(map[string]string{"lib": lib, "app": app})
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	checkExec(modules, "app:scaled", nil, []Value{int64(21)}, t)
+	checkExec(modules, "app:second", nil, []Value{int64(3)}, t)
+	checkExec(modules, "app:name", nil, []Value{"one"}, t)
+	checkExec(modules, "app:quoted", nil, []Value{int64(2)}, t)
+	checkExec(modules, "lib:answer", nil, []Value{int64(42)}, t)
+
+	codes := []string{
+		"func f [] [string] { lib:answer; }",
+		"func f [] [string] { lib:secret; }",
+		"const answer 1 const answer 2",
+		"const answer 1 func answer [] [] { }",
+		"const l list<int>[1.0]",
+		"type point { x: int } const l list<point>[]",
+	}
+
+	for _, code := range codes {
+		_, err := loadTestModules(map[string]string{"lib": lib, "app": code})
+
+		if err == nil {
+			t.Fatalf("Expected an error but got none for: %s", code)
+		}
+	}
+}
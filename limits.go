@@ -0,0 +1,154 @@
+package gocat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Limits restricts the resources an execution may use. A limit of zero
+// means that the resource is not limited.
+type Limits struct {
+	// MaxSteps is the maximum number of literals, verbs, ifs and
+	// matches executed.
+	MaxSteps uint64
+
+	// MaxStackDepth is the maximum number of values on the value
+	// stack summed over all frames.
+	MaxStackDepth uint64
+
+	// MaxCallDepth is the maximum number of nested function calls.
+	MaxCallDepth uint64
+
+	// MaxAlloc is the maximum number of bytes allocated for lists,
+	// maps, records and strings created during the execution. String
+	// literals are not counted. The size of values is an estimate.
+	MaxAlloc uint64
+}
+
+type LimitExceededError struct {
+	Limit string
+	Max   uint64
+	Pos   *FilePos
+}
+
+func (lee *LimitExceededError) Error() string {
+	if lee.Pos == nil {
+		return fmt.Sprintf("Limit exceeded: Limit of %d for %s exceeded.", lee.Max, lee.Limit)
+	}
+
+	return fmt.Sprintf("Limit exceeded %s: Limit of %d for %s exceeded.", lee.Pos, lee.Max, lee.Limit)
+}
+
+// ctxCheckInterval is the number of steps after which the context of an
+// execution is checked for cancellation.
+const ctxCheckInterval = 256
+
+// Estimated sizes of values in bytes.
+const (
+	valueSize  = 16
+	listSize   = 24
+	mapSize    = 48
+	entrySize  = 2 * valueSize
+	recordSize = 24
+	stringSize = 16
+)
+
+// isFatal returns true for errors that abort an execution and can't be
+// caught by `try`.
+func isFatal(err error) bool {
+	if _, ok := err.(*LimitExceededError); ok {
+		return true
+	}
+
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrAborted)
+}
+
+// currentPos returns the position of the verb currently being executed
+// in the frame.
+func (fr *frame) currentPos() *FilePos {
+	if fr == nil {
+		return nil
+	}
+
+	return fr.pos
+}
+
+// step accounts for the execution of a single step.
+func (rt *Runtime) step(fr *frame) error {
+	rt.steps++
+
+	if rt.prof != nil {
+		rt.prof.step(rt.frames)
+	}
+
+	if rt.limits.MaxSteps > 0 && rt.steps > rt.limits.MaxSteps {
+		return &LimitExceededError{
+			Limit: "steps",
+			Max:   rt.limits.MaxSteps,
+			Pos:   fr.currentPos(),
+		}
+	}
+
+	if rt.steps%ctxCheckInterval == 0 {
+		return rt.ctx.Err()
+	}
+
+	return nil
+}
+
+// checkStack checks the depth of the value stack of the frame fr.
+func (rt *Runtime) checkStack(fr *frame, stack []Value) error {
+	if rt.limits.MaxStackDepth == 0 || fr == nil {
+		return nil
+	}
+
+	if fr.base+uint64(len(stack)) > rt.limits.MaxStackDepth {
+		return &LimitExceededError{
+			Limit: "stack depth",
+			Max:   rt.limits.MaxStackDepth,
+			Pos:   fr.currentPos(),
+		}
+	}
+
+	return nil
+}
+
+// checkCallDepth checks the number of nested function calls.
+func (rt *Runtime) checkCallDepth() error {
+	if rt.limits.MaxCallDepth == 0 {
+		return nil
+	}
+
+	if uint64(len(rt.frames)) > rt.limits.MaxCallDepth {
+		return &LimitExceededError{
+			Limit: "call depth",
+			Max:   rt.limits.MaxCallDepth,
+			Pos:   rt.frames[len(rt.frames)-1].currentPos(),
+		}
+	}
+
+	return nil
+}
+
+// Alloc accounts for the allocation of n bytes. Builtins allocating
+// memory should call Alloc and return the error if it fails.
+func (rt *Runtime) Alloc(n int) error {
+	rt.alloced += uint64(n)
+
+	if rt.limits.MaxAlloc > 0 && rt.alloced > rt.limits.MaxAlloc {
+		return &LimitExceededError{
+			Limit: "memory",
+			Max:   rt.limits.MaxAlloc,
+		}
+	}
+
+	return nil
+}
+
+// AllocString accounts for the allocation of the string s. Builtins
+// creating strings should call AllocString and return the error if it
+// fails.
+func (rt *Runtime) AllocString(s string) error {
+	return rt.Alloc(stringSize + len(s))
+}
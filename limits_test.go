@@ -0,0 +1,235 @@
+package gocat
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+const limitsTestCode = `
+func loop [] [] {
+	test:loop;
+}
+
+func guarded [] [] {
+	'test:loop 'test:handler try;
+}
+
+func handler [(e error)] [] {
+}
+
+func many [] [int int int int int int] {
+	1 2 3 4 5 6;
+}
+
+func nested [] [int int int int] {
+	1 2 test:many.three;
+}
+
+func many.three [] [int int] {
+	1 2;
+}
+
+func biglist [] [int] {
+	list<int>[1 2 3 4 5 6 7 8 9 10] len;
+}
+`
+
+func TestLimitSteps(t *testing.T) {
+	modules := mustLoadTestModule("test", limitsTestCode, t)
+
+	rt := NewRuntime(modules)
+	rt.SetLimits(Limits{MaxSteps: 1000})
+
+	mustExceedLimit(rt, "test:loop", "steps", t)
+
+	// Limits can't be caught by try.
+	mustExceedLimit(rt, "test:guarded", "steps", t)
+
+	// Each execution starts with a fresh budget.
+	rt.SetLimits(Limits{MaxSteps: 7})
+	checkExecRuntime(rt, "test:many", 6, t)
+	checkExecRuntime(rt, "test:many", 6, t)
+
+	rt.SetLimits(Limits{MaxSteps: 5})
+	mustExceedLimit(rt, "test:many", "steps", t)
+}
+
+func TestLimitCallDepth(t *testing.T) {
+	modules := mustLoadTestModule("test", limitsTestCode, t)
+
+	rt := NewRuntime(modules)
+	rt.SetLimits(Limits{MaxCallDepth: 100})
+
+	mustExceedLimit(rt, "test:loop", "call depth", t)
+
+	if len(rt.frames) != 0 {
+		t.Fatalf("Expected no frames but got %d.", len(rt.frames))
+	}
+}
+
+func TestLimitStackDepth(t *testing.T) {
+	modules := mustLoadTestModule("test", limitsTestCode, t)
+
+	rt := NewRuntime(modules)
+	rt.SetLimits(Limits{MaxStackDepth: 6})
+	checkExecRuntime(rt, "test:many", 6, t)
+	checkExecRuntime(rt, "test:nested", 4, t)
+
+	rt.SetLimits(Limits{MaxStackDepth: 5})
+	mustExceedLimit(rt, "test:many", "stack depth", t)
+
+	// The values of the callers count as well.
+	rt.SetLimits(Limits{MaxStackDepth: 3})
+	mustExceedLimit(rt, "test:nested", "stack depth", t)
+}
+
+func TestLimitAlloc(t *testing.T) {
+	modules := mustLoadTestModule("test", limitsTestCode, t)
+
+	rt := NewRuntime(modules)
+	rt.SetLimits(Limits{MaxAlloc: 1000})
+	checkExecRuntime(rt, "test:biglist", 1, t)
+
+	rt.SetLimits(Limits{MaxAlloc: 100})
+	mustExceedLimit(rt, "test:biglist", "memory", t)
+}
+
+func TestLimitAllocString(t *testing.T) {
+	code := `
+func long [] [string] {
+	"ab" 100 repeat;
+}
+
+func short [] [string] {
+	"ab" 2 repeat;
+}
+`
+	host := NewHost()
+	host.Register("repeat", "", &FuncType{
+		ArgTypes: []Type{&PrimType{Type: "string"}, &PrimType{Type: "int"}},
+		RetTypes: []Type{&PrimType{Type: "string"}},
+	}, func(rt *Runtime, stack []Value) ([]Value, error) {
+		s := strings.Repeat(stack[len(stack)-2].(string), int(stack[len(stack)-1].(int64)))
+
+		err := rt.AllocString(s)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return append(stack[:len(stack)-2], s), nil
+	})
+
+	modules := mustLoadTestModuleHost("test", code, host, nil, t)
+
+	rt := NewRuntime(modules)
+	rt.SetHost(host, nil)
+	rt.SetLimits(Limits{MaxAlloc: 100})
+
+	checkExecRuntime(rt, "test:short", 1, t)
+	mustExceedLimit(rt, "test:long", "memory", t)
+}
+
+func TestExecContext(t *testing.T) {
+	code := limitsTestCode + `
+func cancelling [] [] {
+	list<int>[` + strings.Repeat("1 ", 1000) + `] 'test:ignore each;
+	test:loop;
+}
+
+func ignore [(i int)] [] {
+	cancel;
+}
+`
+	ctx, cancel := context.WithCancel(context.Background())
+
+	host := NewHost()
+	host.Register("cancel", "", &FuncType{ArgTypes: []Type{}, RetTypes: []Type{}},
+		func(rt *Runtime, stack []Value) ([]Value, error) {
+			cancel()
+			return stack, nil
+		})
+
+	modules := mustLoadTestModuleHost("test", code, host, nil, t)
+
+	rt := NewRuntime(modules)
+	rt.SetHost(host, nil)
+
+	_, err := rt.ExecContext(ctx, "test:cancelling", nil)
+
+	if err != context.Canceled {
+		t.Fatalf("Expected context.Canceled but got: %v", err)
+	}
+
+	// Already cancelled contexts abort right away.
+	_, err = rt.ExecContext(ctx, "test:many", nil)
+
+	if err != context.Canceled {
+		t.Fatalf("Expected context.Canceled but got: %v", err)
+	}
+}
+
+func TestExecContextHost(t *testing.T) {
+	code := `
+func waiting [] [] {
+	wait;
+}
+`
+	host := NewHost()
+	host.Register("wait", "", &FuncType{ArgTypes: []Type{}, RetTypes: []Type{}},
+		func(rt *Runtime, stack []Value) ([]Value, error) {
+			<-rt.Context().Done()
+			return nil, rt.Context().Err()
+		})
+
+	modules := mustLoadTestModuleHost("test", code, host, nil, t)
+
+	rt := NewRuntime(modules)
+	rt.SetHost(host, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := rt.ExecContext(ctx, "test:waiting", nil)
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected context.DeadlineExceeded but got: %v", err)
+	}
+
+	if rt.Context().Err() != nil {
+		t.Fatalf("Expected the context to be reset after the execution.")
+	}
+}
+
+func checkExecRuntime(rt *Runtime, fqname string, n int, t *testing.T) {
+	rets, err := rt.Exec(fqname, nil)
+
+	if err != nil {
+		t.Fatalf("Unexpected error for %s: %s", fqname, err.Error())
+		return
+	}
+
+	if len(rets) != n {
+		t.Fatalf("Expected %d values but got %d for %s.", n, len(rets), fqname)
+		return
+	}
+}
+
+func mustExceedLimit(rt *Runtime, fqname string, limit string, t *testing.T) {
+	_, err := rt.Exec(fqname, nil)
+
+	lee, ok := err.(*LimitExceededError)
+
+	if !ok {
+		t.Fatalf("Expected LimitExceededError but got %v for %s.", err, fqname)
+		return
+	}
+
+	if lee.Limit != limit {
+		t.Fatalf("Expected limit %s but got %s for %s.", limit, lee.Limit, fqname)
+		return
+	}
+}
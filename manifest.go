@@ -0,0 +1,283 @@
+package gocat
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// ManifestFile is the name of the optional manifest of a module. A
+// manifest consists of lines of the form
+//
+//	module geo
+//	version 1.2.0
+//	require math 1.0.0
+//	export origin
+//
+// Empty lines and lines starting with `#` are ignored. `module` is
+// required, all other directives are optional. `require` and `export`
+// may be repeated. Exported functions are public like functions
+// declared with `pub`.
+const ManifestFile = "gocat.mod"
+
+type Manifest struct {
+	// Name is the name of the module which replaces the name of the
+	// directory of the module.
+	Name     string
+	Version  *Version
+	Requires []*Require
+	Exports  []string
+}
+
+// Require is a module required by a module. A loaded module satisfies
+// the requirement if its version is at least Version.
+type Require struct {
+	Module  string
+	Version *Version
+}
+
+type Version struct {
+	Major uint64
+	Minor uint64
+	Patch uint64
+}
+
+func (v *Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// Less returns true if v is an older version than other.
+func (v *Version) Less(other *Version) bool {
+	if v.Major != other.Major {
+		return v.Major < other.Major
+	}
+
+	if v.Minor != other.Minor {
+		return v.Minor < other.Minor
+	}
+
+	return v.Patch < other.Patch
+}
+
+// ParseVersion parses a version of the form `major.minor.patch`.
+func ParseVersion(s string) (*Version, error) {
+	parts := strings.Split(s, ".")
+
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("Invalid version `%s`. Versions look like `1.2.3`.", s)
+	}
+
+	nums := make([]uint64, 3)
+
+	for i, part := range parts {
+		num, err := strconv.ParseUint(part, 10, 64)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid version `%s`. Versions look like `1.2.3`.", s)
+		}
+
+		nums[i] = num
+	}
+
+	return &Version{
+		Major: nums[0],
+		Minor: nums[1],
+		Patch: nums[2],
+	}, nil
+}
+
+// isName returns true if s is a valid name of a module or of a function
+// of a module.
+func isName(s string) bool {
+	if s == "" || !isletter(rune(s[0])) {
+		return false
+	}
+
+	for _, rn := range s {
+		if !isident(rn) || rn == ':' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// manifestDirectives maps the directives of manifests to their number
+// of arguments.
+var manifestDirectives = map[string]int{
+	"module":  1,
+	"version": 1,
+	"require": 2,
+	"export":  1,
+}
+
+// ParseManifest parses a manifest.
+func ParseManifest(r io.Reader) (*Manifest, error) {
+	manifest := &Manifest{}
+	seen := make(map[string]bool)
+	exported := make(map[string]bool)
+
+	scanner := bufio.NewScanner(r)
+	lineno := 0
+
+	for scanner.Scan() {
+		lineno++
+
+		fields := strings.Fields(scanner.Text())
+
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		directive := fields[0]
+		args := fields[1:]
+
+		n, ok := manifestDirectives[directive]
+
+		if !ok {
+			return nil, fmt.Errorf("Line %d: Unknown directive `%s`.", lineno, directive)
+		}
+
+		if len(args) != n {
+			return nil, fmt.Errorf("Line %d: `%s` takes %d arguments but got %d.", lineno, directive, n, len(args))
+		}
+
+		if seen[directive] && (directive == "module" || directive == "version") {
+			return nil, fmt.Errorf("Line %d: Duplicate `%s`.", lineno, directive)
+		}
+
+		seen[directive] = true
+
+		switch directive {
+		case "module":
+			if !isName(args[0]) {
+				return nil, fmt.Errorf("Line %d: Invalid module name `%s`.", lineno, args[0])
+			}
+
+			manifest.Name = args[0]
+		case "version":
+			version, err := ParseVersion(args[0])
+
+			if err != nil {
+				return nil, fmt.Errorf("Line %d: %s", lineno, err.Error())
+			}
+
+			manifest.Version = version
+		case "require":
+			if !isName(args[0]) {
+				return nil, fmt.Errorf("Line %d: Invalid module name `%s`.", lineno, args[0])
+			}
+
+			for _, req := range manifest.Requires {
+				if req.Module == args[0] {
+					return nil, fmt.Errorf("Line %d: Duplicate requirement of module `%s`.", lineno, args[0])
+				}
+			}
+
+			version, err := ParseVersion(args[1])
+
+			if err != nil {
+				return nil, fmt.Errorf("Line %d: %s", lineno, err.Error())
+			}
+
+			manifest.Requires = append(manifest.Requires, &Require{
+				Module:  args[0],
+				Version: version,
+			})
+		case "export":
+			if !isName(args[0]) {
+				return nil, fmt.Errorf("Line %d: Invalid function name `%s`.", lineno, args[0])
+			}
+
+			if exported[args[0]] {
+				return nil, fmt.Errorf("Line %d: Duplicate export of `%s`.", lineno, args[0])
+			}
+
+			exported[args[0]] = true
+			manifest.Exports = append(manifest.Exports, args[0])
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if manifest.Name == "" {
+		return nil, fmt.Errorf("Missing `module`.")
+	}
+
+	return manifest, nil
+}
+
+// loadManifest loads the manifest of the module in the directory dir of
+// fsys. It returns nil if the module has no manifest.
+func loadManifest(fsys fs.FS, dir string) (*Manifest, error) {
+	f, err := fsys.Open(path.Join(dir, ManifestFile))
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	return ParseManifest(f)
+}
+
+// applyExports makes the functions exported by the manifest of the
+// module public.
+func (m *Module) applyExports() error {
+	if m.Manifest == nil {
+		return nil
+	}
+
+	for _, name := range m.Manifest.Exports {
+		if m.Funcs[name] == nil {
+			return fmt.Errorf("Exported function `%s` does not exist.", name)
+		}
+
+		m.Funcs[name].Pub = true
+	}
+
+	return nil
+}
+
+// checkRequires checks that the modules required by the manifests of
+// the modules are loaded in a version satisfying the requirements.
+func checkRequires(modules map[string]*Module) error {
+	for _, name := range sortedNames(modules) {
+		module := modules[name]
+
+		if module.Manifest == nil {
+			continue
+		}
+
+		for _, req := range module.Manifest.Requires {
+			other := modules[req.Module]
+
+			if other == nil {
+				return fmt.Errorf("Module `%s` requires module `%s` which is not loaded.", name, req.Module)
+			}
+
+			if other.Manifest == nil || other.Manifest.Version == nil {
+				return fmt.Errorf("Module `%s` requires version %s of module `%s` which has no version.",
+					name, req.Version, req.Module)
+			}
+
+			if other.Manifest.Version.Less(req.Version) {
+				return fmt.Errorf("Module `%s` requires version %s of module `%s` but version %s is loaded.",
+					name, req.Version, req.Module, other.Manifest.Version)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,142 @@
+package gocat
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestParseManifest(t *testing.T) {
+	code := `
+# The geometry module.
+module geo
+version 1.2.3
+
+require math 0.1.0
+require str 2.0.0
+export origin
+export point.x
+`
+	manifest, err := ParseManifest(strings.NewReader(code))
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	exp := &Manifest{
+		Name:    "geo",
+		Version: &Version{1, 2, 3},
+		Requires: []*Require{
+			{Module: "math", Version: &Version{0, 1, 0}},
+			{Module: "str", Version: &Version{2, 0, 0}},
+		},
+		Exports: []string{"origin", "point.x"},
+	}
+
+	if !reflect.DeepEqual(manifest, exp) {
+		t.Fatalf("Unexpected manifest: %#v", manifest)
+	}
+
+	mustErrorManifest("version 1.0.0\n", t)
+	mustErrorManifest("module geo\nmodule geo\n", t)
+	mustErrorManifest("module geo:x\n", t)
+	mustErrorManifest("module geo\nversion 1.0\n", t)
+	mustErrorManifest("module geo\nversion 1.x.0\n", t)
+	mustErrorManifest("module geo\nversion 1.0.0 2.0.0\n", t)
+	mustErrorManifest("module geo\nrequire math\n", t)
+	mustErrorManifest("module geo\nrequire math 1.0.0\nrequire math 1.1.0\n", t)
+	mustErrorManifest("module geo\nexport a\nexport a\n", t)
+	mustErrorManifest("module geo\nimport math\n", t)
+}
+
+func mustErrorManifest(code string, t *testing.T) {
+	_, err := ParseManifest(strings.NewReader(code))
+
+	if err == nil {
+		t.Fatalf("Expected an error parsing %q but got none.", code)
+	}
+}
+
+func TestVersionLess(t *testing.T) {
+	versions := []*Version{{0, 0, 1}, {0, 1, 0}, {0, 1, 2}, {1, 0, 0}, {1, 10, 0}}
+
+	for i := range versions {
+		for j := range versions {
+			if versions[i].Less(versions[j]) != (i < j) {
+				t.Fatalf("Wrong order of %s and %s.", versions[i], versions[j])
+			}
+		}
+	}
+}
+
+func TestLoadModuleManifest(t *testing.T) {
+	fsys := fstest.MapFS{
+		"src/gocat.mod": &fstest.MapFile{
+			Data: []byte("module geo\nversion 1.0.0\nexport origin\nexport point.x\n"),
+		},
+		"src/point.gct": &fstest.MapFile{
+			Data: []byte("type point { x : int y : int }\nfunc origin [] [point] { 0 0 geo:point; }\n"),
+		},
+		"app/gocat.mod": &fstest.MapFile{
+			Data: []byte("module app\nrequire geo 1.0.0\n"),
+		},
+		"app/main.gct": &fstest.MapFile{
+			Data: []byte("func x [] [int] { geo:origin geo:point.x; }\n"),
+		},
+		"bad/gocat.mod": &fstest.MapFile{
+			Data: []byte("module bad\nexport missing\n"),
+		},
+		"invalid/gocat.mod": &fstest.MapFile{
+			Data: []byte("module\n"),
+		},
+	}
+
+	geo, err := LoadModuleFS(fsys, "src")
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	if geo.Name != "geo" || geo.Types["point"].Name != "geo:point" || geo.Manifest.Version.String() != "1.0.0" {
+		t.Fatalf("Unexpected module: %q", geo.Name)
+	}
+
+	app, err := LoadModuleFS(fsys, "app")
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	err = TypeCheck(map[string]*Module{"geo": geo, "app": app})
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	if TypeCheck(map[string]*Module{"app": app}) == nil {
+		t.Fatalf("Expected an error for a missing required module.")
+	}
+
+	geo.Manifest.Version = &Version{0, 9, 0}
+
+	if TypeCheck(map[string]*Module{"geo": geo, "app": app}) == nil {
+		t.Fatalf("Expected an error for a too old required module.")
+	}
+
+	geo.Manifest = nil
+
+	if TypeCheck(map[string]*Module{"geo": geo, "app": app}) == nil {
+		t.Fatalf("Expected an error for a required module without version.")
+	}
+
+	for _, dir := range []string{"bad", "invalid"} {
+		_, err = LoadModuleFS(fsys, dir)
+
+		lme, ok := err.(*LoadModuleError)
+
+		if !ok || lme.FilePath != dir+"/gocat.mod" {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+}
@@ -2,20 +2,46 @@ package gocat
 
 import (
 	"fmt"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 )
 
 type Module struct {
 	Name  string
 	Path  string
 	Funcs map[string]*Func
+	Types map[string]*RecordType
+
+	// Manifest is the manifest of the module or nil if the module does
+	// not have one.
+	Manifest *Manifest
+
+	// Imports are the imports of all files of the module.
+	Imports []*ImportNode
+
+	// Warnings are the warnings found by the last type check.
+	Warnings []*Warning
 }
 
 type Func struct {
 	Type     *FuncType
 	Name     string
 	FuncNode *FuncNode
+
+	// Native is the implementation of functions that are not defined
+	// in gocat code such as the constructors and accessors of records.
+	// FuncNode is nil for such functions.
+	Native BuiltinFunc
+
+	// Pub is true if the function may be referenced by other modules.
+	Pub bool
+
+	// Const is the value of constants. Constants are functions without
+	// arguments returning Const. They are inlined by the runtime.
+	Const Value
 }
 
 type LoadModuleError struct {
@@ -34,6 +60,7 @@ func mkFunc(fn *FuncNode) *Func {
 		FuncNode: fn,
 		Type:     mkFuncType(fn),
 		Name:     fn.Name,
+		Pub:      fn.Pub,
 	}
 }
 
@@ -48,12 +75,356 @@ func mkFuncType(fn *FuncNode) *FuncType {
 	}
 }
 
+// mkRecordFuncs creates the functions of a record type which are the
+// constructor `point` taking the values of all fields, the getters
+// `point.x` and the setters `point.x.set` returning a copy of the record
+// with the field set. The functions are public if pub is true. pub only
+// affects these functions as other modules may always name the type.
+func mkRecordFuncs(name string, rec *RecordType, pub bool) []*Func {
+	funcs := make([]*Func, 0, 1+2*len(rec.Fields))
+
+	fieldTypes := make([]Type, len(rec.Fields))
+	for i, field := range rec.Fields {
+		fieldTypes[i] = field.Type
+	}
+
+	funcs = append(funcs, &Func{
+		Name: name,
+		Pub:  pub,
+		Type: &FuncType{
+			ArgTypes: fieldTypes,
+			RetTypes: []Type{rec},
+		},
+		Native: func(rt *Runtime, stack []Value) ([]Value, error) {
+			m := len(rec.Fields)
+
+			err := rt.Alloc(recordSize + valueSize*m)
+
+			if err != nil {
+				return nil, err
+			}
+
+			fields := append(make([]Value, 0, m), stack[len(stack)-m:]...)
+
+			return append(stack[:len(stack)-m], &RecordValue{
+				Type:   rec,
+				Fields: fields,
+			}), nil
+		},
+	})
+
+	for i, field := range rec.Fields {
+		i := i
+
+		funcs = append(funcs, &Func{
+			Name: name + "." + field.Name,
+			Pub:  pub,
+			Type: &FuncType{
+				ArgTypes: []Type{rec},
+				RetTypes: []Type{field.Type},
+			},
+			Native: func(rt *Runtime, stack []Value) ([]Value, error) {
+				rv := stack[len(stack)-1].(*RecordValue)
+				return append(stack[:len(stack)-1], rv.Fields[i]), nil
+			},
+		})
+
+		funcs = append(funcs, &Func{
+			Name: name + "." + field.Name + ".set",
+			Pub:  pub,
+			Type: &FuncType{
+				ArgTypes: []Type{rec, field.Type},
+				RetTypes: []Type{rec},
+			},
+			Native: func(rt *Runtime, stack []Value) ([]Value, error) {
+				val := stack[len(stack)-1]
+				rv := stack[len(stack)-2].(*RecordValue)
+
+				err := rt.Alloc(recordSize + valueSize*len(rv.Fields))
+
+				if err != nil {
+					return nil, err
+				}
+
+				fields := append(make([]Value, 0, len(rv.Fields)), rv.Fields...)
+				fields[i] = val
+
+				return append(stack[:len(stack)-2], &RecordValue{
+					Type:   rv.Type,
+					Fields: fields,
+				}), nil
+			},
+		})
+	}
+
+	return funcs
+}
+
+// mkConst creates the function of a constant. The value of the constant
+// is computed once.
+func mkConst(cn *ConstNode) (*Func, error) {
+	types, err := InferTypes(cn.Value, nil, nil)
+
+	if err != nil {
+		return nil, fmt.Errorf("Constant `%s` %s: %s", cn.Name, cn.Token.Pos, err.Error())
+	}
+
+	if len(types) != 1 || !isConstType(types[0]) {
+		return nil, fmt.Errorf("Constant `%s` %s must be an int, float, bool, string or a collection of them.",
+			cn.Name, cn.Token.Pos)
+	}
+
+	value := constValue(cn.Value)
+
+	return &Func{
+		Name: cn.Name,
+		Type: &FuncType{
+			ArgTypes: []Type{},
+			RetTypes: types,
+		},
+		Pub:   cn.Pub,
+		Const: value,
+		Native: func(rt *Runtime, stack []Value) ([]Value, error) {
+			return append(stack, value), nil
+		},
+	}, nil
+}
+
+// isConstType returns true if values of the type typ may be constants.
+func isConstType(typ Type) bool {
+	switch typ.(type) {
+	case *PrimType:
+		switch typ.(*PrimType).Type {
+		case "int", "float", "bool", "string":
+			return true
+		}
+	case *ListType:
+		return isConstType(typ.(*ListType).ElemType)
+	case *MapType:
+		return isConstType(typ.(*MapType).KeyType) && isConstType(typ.(*MapType).ValueType)
+	case *UnionType:
+		for _, member := range typ.(*UnionType).Types {
+			if !isConstType(member) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// constValue returns the value of a literal.
+func constValue(node Node) Value {
+	switch node.(type) {
+	case *LitIntNode:
+		return node.(*LitIntNode).Value
+	case *LitFloatNode:
+		return node.(*LitFloatNode).Value
+	case *LitBoolNode:
+		return node.(*LitBoolNode).Value
+	case *LitStringNode:
+		return node.(*LitStringNode).Value
+	case *LitListNode:
+		lit := node.(*LitListNode)
+		elems := make([]Value, len(lit.Elems))
+
+		for i, elem := range lit.Elems {
+			elems[i] = constValue(elem)
+		}
+
+		return &ListValue{
+			Type:  lit.Type,
+			Elems: elems,
+		}
+	case *LitMapNode:
+		lit := node.(*LitMapNode)
+		entries := make(map[Value]Value, len(lit.Keys))
+
+		for i := range lit.Keys {
+			entries[constValue(lit.Keys[i])] = constValue(lit.Values[i])
+		}
+
+		return &MapValue{
+			Type:    lit.Type,
+			Entries: entries,
+		}
+	}
+
+	panic("BUG: constant is not a literal?")
+}
+
+// resolveType replaces references to record types with the record types.
+// The parser can't tell references to record types apart from primitive
+// types so they are parsed as primitive types.
+func resolveType(typ Type, lookup func(string) *RecordType) Type {
+	switch typ.(type) {
+	case *PrimType:
+		rec := lookup(typ.(*PrimType).Type)
+
+		if rec != nil {
+			return rec
+		}
+
+		return typ
+	case *RecordType:
+		// Records are resolved again by name because cached code may
+		// refer to a record that has since been redeclared.
+		rec := lookup(typ.(*RecordType).Name)
+
+		if rec != nil {
+			return rec
+		}
+
+		return typ
+	case *ListType:
+		return &ListType{
+			ElemType: resolveType(typ.(*ListType).ElemType, lookup),
+		}
+	case *MapType:
+		mt := typ.(*MapType)
+		return &MapType{
+			KeyType:   resolveType(mt.KeyType, lookup),
+			ValueType: resolveType(mt.ValueType, lookup),
+		}
+	case *FuncType:
+		ft := typ.(*FuncType)
+		return &FuncType{
+			ArgTypes: resolveTypes(ft.ArgTypes, lookup),
+			RetTypes: resolveTypes(ft.RetTypes, lookup),
+		}
+	case *ContractType:
+		ct := typ.(*ContractType)
+		funcs := make(map[string]*FuncType)
+
+		for name, ft := range ct.Funcs {
+			funcs[name] = resolveType(ft, lookup).(*FuncType)
+		}
+
+		return &ContractType{
+			Funcs: funcs,
+		}
+	case *UnionType:
+		// Resolving changes the order of the types.
+		ut, err := NewUnionType(resolveTypes(typ.(*UnionType).Types, lookup))
+
+		if err != nil {
+			panic("BUG: resolving produced duplicate types?")
+		}
+
+		return ut
+	}
+
+	return typ
+}
+
+func resolveTypes(types []Type, lookup func(string) *RecordType) []Type {
+	resolved := make([]Type, len(types))
+
+	for i, typ := range types {
+		resolved[i] = resolveType(typ, lookup)
+	}
+
+	return resolved
+}
+
+// resolveNodes resolves the types mentioned in nodes.
+func resolveNodes(nodes []Node, lookup func(string) *RecordType) {
+	for _, node := range nodes {
+		switch node.(type) {
+		case *ExpNode:
+			resolveNodes(node.(*ExpNode).Exps, lookup)
+		case *LitListNode:
+			lit := node.(*LitListNode)
+			lit.Type = resolveType(lit.Type, lookup).(*ListType)
+			resolveNodes(lit.Elems, lookup)
+		case *LitMapNode:
+			lit := node.(*LitMapNode)
+			lit.Type = resolveType(lit.Type, lookup).(*MapType)
+			resolveNodes(lit.Keys, lookup)
+			resolveNodes(lit.Values, lookup)
+		case *IfElseNode:
+			ifn := node.(*IfElseNode)
+			resolveNodes([]Node{ifn.Condition}, lookup)
+			resolveNodes(ifn.ThenBlock, lookup)
+			resolveNodes(ifn.ElseBlock, lookup)
+		case *MatchNode:
+			for _, mc := range node.(*MatchNode).Cases {
+				mc.Type = resolveType(mc.Type, lookup)
+				resolveNodes(mc.Body, lookup)
+			}
+		}
+	}
+}
+
+// resolveModule resolves the types mentioned in the records and
+// functions of a module and updates the types of the functions.
+func resolveModule(module *Module, lookup func(string) *RecordType) {
+	for _, rec := range module.Types {
+		for i := range rec.Fields {
+			rec.Fields[i].Type = resolveType(rec.Fields[i].Type, lookup)
+		}
+	}
+
+	for _, fn := range module.Funcs {
+		if fn.FuncNode == nil {
+			continue
+		}
+
+		for i := range fn.FuncNode.Args {
+			fn.FuncNode.Args[i].Type = resolveType(fn.FuncNode.Args[i].Type, lookup)
+		}
+
+		fn.FuncNode.RetTypes = resolveTypes(fn.FuncNode.RetTypes, lookup)
+		resolveNodes(fn.FuncNode.Body, lookup)
+
+		fn.Type = mkFuncType(fn.FuncNode)
+	}
+
+	// The functions of records are recreated because their types
+	// depend on the types of the fields.
+	for name, rec := range module.Types {
+		for _, fn := range mkRecordFuncs(name, rec, false) {
+			// Public functions stay public.
+			fn.Pub = module.Funcs[fn.Name] != nil && module.Funcs[fn.Name].Pub
+			module.Funcs[fn.Name] = fn
+		}
+	}
+}
+
+// LoadModule loads the module in the directory mpath of the real
+// filesystem.
 func LoadModule(mpath string) (*Module, error) {
-	mname := filepath.Base(mpath)
+	return loadModule(mpath, false)
+}
 
-	// Make sure that mpath is a directory.
+// LoadModuleFS loads the module in the directory mpath of fsys. mpath
+// is a slash separated path as used by io/fs. A module loaded from
+// the root "." of fsys needs a manifest to name it.
+func LoadModuleFS(fsys fs.FS, mpath string) (*Module, error) {
+	return loadModuleFS(fsys, mpath, mpath, false)
+}
 
-	f, err := os.Open(mpath)
+// LoadTestModule is like LoadModule but also loads the test files of
+// the module.
+func LoadTestModule(mpath string) (*Module, error) {
+	return loadModule(mpath, true)
+}
+
+// LoadTestModuleFS is like LoadModuleFS but also loads the test files of
+// the module.
+func LoadTestModuleFS(fsys fs.FS, mpath string) (*Module, error) {
+	return loadModuleFS(fsys, mpath, mpath, true)
+}
+
+// loadModule loads the module in the directory mpath of the real
+// filesystem. mpath is made absolute first so that paths such as ".."
+// still have a parent directory and a name.
+func loadModule(mpath string, tests bool) (*Module, error) {
+	mpath = filepath.Clean(mpath)
+
+	apath, err := filepath.Abs(mpath)
 
 	if err != nil {
 		return nil, &LoadModuleError{
@@ -63,11 +434,22 @@ func LoadModule(mpath string) (*Module, error) {
 		}
 	}
 
-	fi, err := f.Stat()
+	return loadModuleFS(os.DirFS(filepath.Dir(apath)), filepath.Base(apath), mpath, tests)
+}
+
+// loadModuleFS loads the module in the directory dir of fsys. Paths
+// in errors and positions are relative to displayPath. Test files are
+// only loaded if tests is true.
+func loadModuleFS(fsys fs.FS, dir string, displayPath string, tests bool) (*Module, error) {
+	mname := path.Base(dir)
+
+	// Make sure that dir is a directory.
+
+	fi, err := fs.Stat(fsys, dir)
 
 	if err != nil {
 		return nil, &LoadModuleError{
-			ModulePath: mpath,
+			ModulePath: displayPath,
 			FilePath:   "<n/a>",
 			Msg:        err.Error(),
 		}
@@ -75,57 +457,176 @@ func LoadModule(mpath string) (*Module, error) {
 
 	if !fi.IsDir() {
 		return nil, &LoadModuleError{
-			ModulePath: mpath,
+			ModulePath: displayPath,
 			FilePath:   "<n/a>",
 			Msg:        "Not a directory.",
 		}
 	}
 
-	funcs := make(map[string]*Func)
+	manifest, err := loadManifest(fsys, dir)
+
+	if err != nil {
+		return nil, &LoadModuleError{
+			ModulePath: displayPath,
+			FilePath:   filepath.Join(displayPath, ManifestFile),
+			Msg:        err.Error(),
+		}
+	}
+
+	if manifest != nil {
+		mname = manifest.Name
+	} else if mname == "." || mname == "/" {
+		return nil, &LoadModuleError{
+			ModulePath: displayPath,
+			FilePath:   "<n/a>",
+			Msg:        "Can not name the module after its path, a manifest is required.",
+		}
+	}
+
+	module := &Module{
+		Name:     mname,
+		Path:     displayPath,
+		Funcs:    make(map[string]*Func),
+		Types:    make(map[string]*RecordType),
+		Manifest: manifest,
+	}
+
+	matches, err := fs.Glob(fsys, path.Join(dir, "*.gct"))
+
+	if err != nil {
+		return nil, &LoadModuleError{
+			ModulePath: displayPath,
+			FilePath:   "<n/a>",
+			Msg:        err.Error(),
+		}
+	}
+
+	if !tests {
+		matches = withoutTestFiles(matches)
+	}
+
+	// Files are parsed concurrently but added to the module in the
+	// order of their names.
+	roots := make([]*RootNode, len(matches))
 
-	matches, err := filepath.Glob(filepath.Join(mpath, "*.gct"))
+	err = forEachParallel(len(matches), func(i int) error {
+		fpath := filepath.Join(displayPath, path.Base(matches[i]))
 
-	for _, fpath := range matches {
-		f, err := os.OpenFile(fpath, os.O_RDONLY, 0)
+		f, err := fsys.Open(matches[i])
 
 		if err != nil {
-			return nil, &LoadModuleError{
+			return &LoadModuleError{
 				FilePath:   fpath,
-				ModulePath: mpath,
+				ModulePath: displayPath,
 				Msg:        err.Error(),
 			}
 		}
 
+		defer f.Close()
+
 		p := NewParser(NewTokenizerReader(f, fpath))
 
-		lfuncs, err := p.Funcs()
+		root, err := p.Root()
 
 		if err != nil {
-			return nil, &LoadModuleError{
+			return &LoadModuleError{
 				FilePath:   fpath,
-				ModulePath: mpath,
+				ModulePath: displayPath,
 				Msg:        err.Error(),
 			}
 		}
 
-		for _, lfunc := range lfuncs {
-			if funcs[lfunc.Name] != nil {
+		roots[i] = root
+		return nil
+	})
 
-				return nil, &LoadModuleError{
-					ModulePath: mpath,
-					FilePath:   fpath,
-					Msg:        fmt.Sprintf("Duplicate function `%s`.", lfunc.Name),
-				}
+	if err != nil {
+		return nil, err
+	}
 
-			} else {
-				funcs[lfunc.Name] = mkFunc(lfunc)
+	for i, root := range roots {
+		err = module.addRoot(root)
+
+		if err != nil {
+			return nil, &LoadModuleError{
+				FilePath:   filepath.Join(displayPath, path.Base(matches[i])),
+				ModulePath: displayPath,
+				Msg:        err.Error(),
 			}
 		}
 	}
 
-	return &Module{
-		Name:  mname,
-		Path:  mpath,
-		Funcs: funcs,
-	}, nil
+	err = module.applyExports()
+
+	if err != nil {
+		return nil, &LoadModuleError{
+			ModulePath: displayPath,
+			FilePath:   filepath.Join(displayPath, ManifestFile),
+			Msg:        err.Error(),
+		}
+	}
+
+	// References to records of other modules are resolved by TypeCheck.
+	resolveModule(module, module.lookupLocalType)
+
+	return module, nil
+}
+
+// addRoot adds the types and functions of a parsed file to the module.
+func (m *Module) addRoot(root *RootNode) error {
+	m.Imports = append(m.Imports, root.Imports...)
+
+	for name, td := range root.TypeDecls {
+		if m.Types[name] != nil {
+			return fmt.Errorf("Duplicate type `%s`.", name)
+		}
+
+		// Records are named by their fully qualified name so that
+		// records of different modules are different types.
+		rec := td.Type.(*RecordType)
+		rec.Name = m.Name + ":" + name
+		m.Types[name] = rec
+
+		for _, rfunc := range mkRecordFuncs(name, rec, td.Pub) {
+			if m.Funcs[rfunc.Name] != nil {
+				return fmt.Errorf("Duplicate function `%s`.", rfunc.Name)
+			}
+
+			m.Funcs[rfunc.Name] = rfunc
+		}
+	}
+
+	for _, cn := range root.Consts {
+		if m.Funcs[cn.Name] != nil {
+			return fmt.Errorf("Duplicate function `%s`.", cn.Name)
+		}
+
+		cfunc, err := mkConst(cn)
+
+		if err != nil {
+			return err
+		}
+
+		m.Funcs[cn.Name] = cfunc
+	}
+
+	for _, lfunc := range root.Funcs {
+		if m.Funcs[lfunc.Name] != nil {
+			return fmt.Errorf("Duplicate function `%s`.", lfunc.Name)
+		}
+
+		m.Funcs[lfunc.Name] = mkFunc(lfunc)
+	}
+
+	return nil
+}
+
+// lookupLocalType looks up a record type of the module by its name
+// which may be qualified with the name of the module.
+func (m *Module) lookupLocalType(name string) *RecordType {
+	if strings.HasPrefix(name, m.Name+":") {
+		name = name[len(m.Name)+1:]
+	}
+
+	return m.Types[name]
 }
@@ -0,0 +1,209 @@
+package gocat
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadModuleFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"mods/geo/point.gct": &fstest.MapFile{
+			Data: []byte("type point { x : int y : int }\n"),
+		},
+		"mods/geo/funcs.gct": &fstest.MapFile{
+			Data: []byte("func origin [] [point] { 0 0 geo:point; }\n"),
+		},
+		"mods/geo/README": &fstest.MapFile{
+			Data: []byte("not gocat code"),
+		},
+		"mods/bad/bad.gct": &fstest.MapFile{
+			Data: []byte("func bad [] [int] {\n"),
+		},
+		"mods/file.gct": &fstest.MapFile{
+			Data: []byte(""),
+		},
+	}
+
+	module, err := LoadModuleFS(fsys, "mods/geo")
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	if module.Name != "geo" || module.Path != "mods/geo" {
+		t.Fatalf("Unexpected name or path: %q %q", module.Name, module.Path)
+	}
+
+	if module.Funcs["origin"] == nil || module.Types["point"] == nil {
+		t.Fatalf("Module is missing functions or types.")
+	}
+
+	modules := map[string]*Module{"geo": module}
+
+	err = TypeCheck(modules)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	_, err = LoadModuleFS(fsys, "mods/bad")
+
+	if err == nil {
+		t.Fatalf("Expected an error but got none.")
+	}
+
+	lme, ok := err.(*LoadModuleError)
+
+	if !ok || lme.FilePath != "mods/bad/bad.gct" {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	_, err = LoadModuleFS(fsys, "mods/file.gct")
+
+	if err == nil {
+		t.Fatalf("Expected an error but got none.")
+	}
+
+	_, err = LoadModuleFS(fsys, "mods/missing")
+
+	if err == nil {
+		t.Fatalf("Expected an error but got none.")
+	}
+
+	// The root of a filesystem has no name to give to the module.
+	sub, err := fs.Sub(fsys, "mods/geo")
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	_, err = LoadModuleFS(sub, ".")
+
+	if err == nil {
+		t.Fatalf("Expected an error but got none.")
+	}
+
+	sub = fstest.MapFS{
+		"gocat.mod": &fstest.MapFile{Data: []byte("module geo\n")},
+		"geo.gct":   &fstest.MapFile{Data: []byte("func one [] [int] { 1; }\n")},
+	}
+
+	module, err = LoadModuleFS(sub, ".")
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	if module.Name != "geo" {
+		t.Fatalf("Unexpected module name: %q", module.Name)
+	}
+}
+
+func TestLoadModule(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "calc")
+
+	err := os.Mkdir(dir, 0755)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	err = os.WriteFile(filepath.Join(dir, "calc.gct"), []byte("func one [] [int] { 1; }\n"), 0644)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	module, err := LoadModule(dir)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	if module.Name != "calc" || module.Path != dir || module.Funcs["one"] == nil {
+		t.Fatalf("Unexpected module: %q %q", module.Name, module.Path)
+	}
+
+	pos := module.Funcs["one"].FuncNode.Token.Pos
+
+	if pos.FilePath != filepath.Join(dir, "calc.gct") {
+		t.Fatalf("Unexpected file path: %q", pos.FilePath)
+	}
+
+	// Relative paths to parent directories name the module after the
+	// directory they resolve to.
+	err = os.Mkdir(filepath.Join(dir, "sub"), 0755)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	wd, err := os.Getwd()
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	defer os.Chdir(wd)
+
+	err = os.Chdir(filepath.Join(dir, "sub"))
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	module, err = LoadModule("..")
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	if module.Name != "calc" || module.Path != ".." || module.Funcs["one"] == nil {
+		t.Fatalf("Unexpected module: %q %q", module.Name, module.Path)
+	}
+
+	module, err = LoadTestModule("..")
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	if module.Name != "calc" {
+		t.Fatalf("Unexpected module name: %q", module.Name)
+	}
+}
+
+func BenchmarkLoadModuleFS(b *testing.B) {
+	fsys := make(fstest.MapFS)
+
+	for i := 0; i < 20; i++ {
+		fsys[fmt.Sprintf("bench/f%02d.gct", i)] = &fstest.MapFile{
+			Data: []byte(benchmarkCode("bench", "f"+benchmarkName(i, 2), 10)),
+		}
+	}
+
+	for _, workers := range []int{1, 0} {
+		name := "sequential"
+
+		if workers == 0 {
+			name = "parallel"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			defer func(old int) { maxWorkers = old }(maxWorkers)
+			maxWorkers = workers
+
+			for i := 0; i < b.N; i++ {
+				_, err := LoadModuleFS(fsys, "bench")
+
+				if err != nil {
+					b.Fatalf("Unexpected error: %s", err.Error())
+				}
+			}
+		})
+	}
+}
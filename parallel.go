@@ -0,0 +1,57 @@
+package gocat
+
+import (
+	"runtime"
+	"sync"
+)
+
+// maxWorkers is the maximum number of goroutines used to parse files and
+// to type check functions. Zero means runtime.GOMAXPROCS(0).
+var maxWorkers = 0
+
+// forEachParallel calls f for each i in [0, n) using a bounded number of
+// goroutines. If any call fails the error with the lowest i is returned
+// so that errors don't depend on scheduling.
+func forEachParallel(n int, f func(i int) error) error {
+	workers := maxWorkers
+
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	if workers > n {
+		workers = n
+	}
+
+	errs := make([]error, n)
+	next := make(chan int)
+
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range next {
+				errs[i] = f(i)
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		next <- i
+	}
+
+	close(next)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
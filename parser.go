@@ -9,6 +9,20 @@ import (
 type Parser struct {
 	tz    Tokenizer
 	tkbuf []*Token
+
+	// aliases maps the aliases of imported modules to the imports and
+	// selected maps the selectively imported names to the imports.
+	aliases  map[string]*ImportNode
+	selected map[string]*ImportNode
+
+	// args are the names of the arguments of the function being parsed
+	// which shadow selectively imported names.
+	args map[string]bool
+
+	// comments are the consecutive comments read since the last token
+	// and docs maps tokens to the comments directly preceding them.
+	comments []*Token
+	docs     map[*Token]string
 }
 
 type ParserError struct {
@@ -24,8 +38,11 @@ func (pe *ParserError) Error() string {
 
 func NewParser(tz Tokenizer) *Parser {
 	return &Parser{
-		tz:    tz,
-		tkbuf: make([]*Token, 0),
+		tz:       tz,
+		tkbuf:    make([]*Token, 0),
+		aliases:  make(map[string]*ImportNode),
+		selected: make(map[string]*ImportNode),
+		docs:     make(map[*Token]string),
 	}
 }
 
@@ -47,19 +64,85 @@ func (p *Parser) read() (*Token, error) {
 		return it, nil
 	}
 
-	tk, err := p.tz.Next()
+	for {
+		tk, err := p.tz.Next()
 
-	if err != nil {
-		return nil, err
+		if err != nil {
+			return nil, err
+		}
+
+		if tk.Type != TT_COMMENT {
+			p.attachDoc(tk)
+			return tk, nil
+		}
+
+		n := len(p.comments)
+
+		if n > 0 && p.comments[n-1].Pos.LineNumber+1 != tk.Pos.LineNumber {
+			p.comments = p.comments[:0]
+		}
+
+		p.comments = append(p.comments, tk)
+	}
+}
+
+// attachDoc records the comments read before tk as the doc comment of tk
+// if they are on the lines directly preceding tk.
+func (p *Parser) attachDoc(tk *Token) {
+	n := len(p.comments)
+
+	if n == 0 {
+		return
+	}
+
+	if p.comments[n-1].Pos.LineNumber+1 == tk.Pos.LineNumber {
+		lines := make([]string, n)
+
+		for i, comment := range p.comments {
+			lines[i] = strings.TrimPrefix(comment.SVal, " ")
+		}
+
+		p.docs[tk] = strings.Join(lines, "\n")
 	}
 
-	return tk, nil
+	p.comments = p.comments[:0]
 }
 
 func (p *Parser) unread(tk *Token) {
 	p.tkbuf = append(p.tkbuf, tk)
 }
 
+// qualify rewrites a name according to the imports of the file.
+func (p *Parser) qualify(name string) string {
+	i := strings.LastIndex(name, ":")
+
+	if i >= 0 {
+		imp := p.aliases[name[:i]]
+
+		if imp == nil {
+			return name
+		}
+
+		imp.used = true
+		return imp.Module + name[i:]
+	}
+
+	if p.args[name] {
+		return name
+	}
+
+	imp := p.selected[name]
+
+	if imp == nil {
+		return name
+	}
+
+	imp.used = true
+	imp.usedNames[name] = true
+
+	return imp.Module + ":" + name
+}
+
 func (p *Parser) parseData() (Node, error) {
 	// Next token must be LITINT or LITFLOAT or IDENT.
 	tk, err := p.read()
@@ -97,9 +180,25 @@ func (p *Parser) parseData() (Node, error) {
 			Value: fv,
 			Token: tk,
 		}, nil
+	case TT_LITBOOL:
+		return &LitBoolNode{
+			Value: tk.SVal == "true",
+			Token: tk,
+		}, nil
+	case TT_LITSTRING:
+		return &LitStringNode{
+			Value: tk.SVal,
+			Token: tk,
+		}, nil
 	case TT_IDENT:
+		switch tk.SVal {
+		case "list", "map":
+			p.unread(tk)
+			return p.parseLitCollection()
+		}
+
 		return &VerbNode{
-			Verb:  tk.SVal,
+			Verb:  p.qualify(tk.SVal),
 			Token: tk,
 		}, nil
 	case TT_QUOT:
@@ -114,7 +213,7 @@ func (p *Parser) parseData() (Node, error) {
 		}
 
 		return &QuotNode{
-			Ident: tk.SVal,
+			Ident: p.qualify(tk.SVal),
 			Token: tk,
 		}, nil
 	default:
@@ -188,8 +287,15 @@ func (p *Parser) parseType() (Type, error) {
 
 	switch tk.Type {
 	case TT_IDENT:
+		switch tk.SVal {
+		case "list":
+			return p.parseListType(tk)
+		case "map":
+			return p.parseMapType(tk)
+		}
+
 		return &PrimType{
-			Type: tk.SVal,
+			Type: p.qualify(tk.SVal),
 		}, nil
 	case TT_LCBRACKET:
 		types := make([]Type, 0)
@@ -204,7 +310,8 @@ func (p *Parser) parseType() (Type, error) {
 			}
 
 			switch tk.Type {
-			case TT_IDENT:
+			case TT_IDENT, TT_LCBRACKET, TT_FUNC:
+				// Nested union types are flattened by NewUnionType.
 				p.unread(tk)
 				typ, err := p.parseType()
 
@@ -213,17 +320,12 @@ func (p *Parser) parseType() (Type, error) {
 				}
 
 				types = append(types, typ)
-			case TT_LCBRACKET:
-				return InvalidType, &ParserError{
-					Token: tk,
-					Msg:   fmt.Sprintf("Unexpected %s. Union types can not be nested.", tk.SVal),
-				}
 			case TT_RCBRACKET:
 				done = true
 			default:
 				return InvalidType, &ParserError{
 					Token: tk,
-					Msg:   fmt.Sprintf("Expected identifier but got `%s`.", tk.SVal),
+					Msg:   fmt.Sprintf("Expected type but got `%s`.", tk.SVal),
 				}
 			}
 
@@ -242,6 +344,8 @@ func (p *Parser) parseType() (Type, error) {
 		}
 
 		return ut, nil
+	case TT_FUNC:
+		return p.parseFuncType(tk)
 	}
 
 	return InvalidType, &ParserError{
@@ -250,100 +354,108 @@ func (p *Parser) parseType() (Type, error) {
 	}
 }
 
-func (p *Parser) Funcs() ([]*FuncNode, error) {
-	return p.parseFuncs()
-}
+// parseTypeParams parses the type parameters of a parameterised type
+// (e.g. `<int float>`).
+func (p *Parser) parseTypeParams() ([]Type, error) {
+	tk, err := p.read()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if tk.Type != TT_LANGLE {
+		return nil, &ParserError{
+			Token: tk,
+			Msg:   fmt.Sprintf("Expected `<` but got `%s`.", tk.SVal),
+		}
+	}
 
-func (p *Parser) parseFuncs() ([]*FuncNode, error) {
-	funcs := make([]*FuncNode, 8) // TODO: resize later
-	fj := 0
+	types := make([]Type, 0)
 
 	for {
-		tk, err := p.read()
+		tk, err = p.read()
 
 		if err != nil {
 			return nil, err
 		}
 
-		if tk.Type == TT_EOF {
-			break
-		}
-
-		if tk.Type != TT_LPAREN {
-			return nil, &ParserError{
-				Token: tk,
-				Msg:   fmt.Sprintf("Expected `(` but got `%s`.", tk.SVal),
-			}
+		if tk.Type == TT_RANGLE {
+			return types, nil
 		}
 
 		p.unread(tk)
 
-		fn, err := p.parseFunc()
+		typ, err := p.parseType()
 
 		if err != nil {
 			return nil, err
 		}
 
-		fn_, ok := fn.(*FuncNode)
+		types = append(types, typ)
+	}
+}
 
-		if !ok {
-			panic("BUG: didn't get *FuncNode")
-		}
+func (p *Parser) parseListType(listtk *Token) (Type, error) {
+	params, err := p.parseTypeParams()
 
-		funcs[fj] = fn_
-		fj++
+	if err != nil {
+		return InvalidType, err
 	}
 
-	return funcs[:fj], nil
-}
-
-func (p *Parser) parseFunc() (Node, error) {
-	// next token must be FUNC
+	if len(params) != 1 {
+		return InvalidType, &ParserError{
+			Token: listtk,
+			Msg:   fmt.Sprintf("`list` takes exactly one type parameter but got %d.", len(params)),
+		}
+	}
 
-	tk, err := p.read()
+	return &ListType{
+		ElemType: params[0],
+	}, nil
+}
 
-	firsttk := tk
+func (p *Parser) parseMapType(maptk *Token) (Type, error) {
+	params, err := p.parseTypeParams()
 
 	if err != nil {
-		return nil, err
+		return InvalidType, err
 	}
 
-	if tk.Type != TT_FUNC {
-		return nil, &ParserError{
-			Token: tk,
-			Msg:   fmt.Sprintf("Expected `func` but got `%s`.", tk.SVal),
+	if len(params) != 2 {
+		return InvalidType, &ParserError{
+			Token: maptk,
+			Msg:   fmt.Sprintf("`map` takes exactly two type parameters but got %d.", len(params)),
 		}
 	}
 
-	// then the next token must be IDENT
+	// The types of keys are checked by checkMapKeys once references to
+	// records have been resolved.
+	return &MapType{
+		KeyType:   params[0],
+		ValueType: params[1],
+	}, nil
+}
 
-	tk, err = p.read()
+// parseLitCollection parses a list or map literal which is the type
+// of the collection followed by its elements in square brackets
+// (e.g. `list<int>[1 2 3]` or `map<int float>[1 1.0 2 2.0]`). The
+// elements of a map literal are alternating keys and values.
+func (p *Parser) parseLitCollection() (Node, error) {
+	firsttk, err := p.read()
 
 	if err != nil {
 		return nil, err
 	}
 
-	if tk.Type != TT_IDENT {
-		return nil, &ParserError{
-			Token: tk,
-			Msg:   fmt.Sprintf("Expected identifier but got `%s`.", tk.SVal),
-		}
-	}
+	p.unread(firsttk)
 
-	funcname := tk.SVal
+	typ, err := p.parseType()
 
-	if strings.ContainsRune(funcname, ':') {
-		return nil, &ParserError{
-			Token: tk,
-			Msg:   fmt.Sprintf("`:` is not allowed in identifiers in this context. Offending identifier is `%s`.", tk.SVal),
-		}
+	if err != nil {
+		return nil, err
 	}
 
-	args := make([]Arg, 8) // TODO: resize later
-	aj := 0
-
-	// then the arguments follow. which is at least one LPAREN then until RPAREN
-	tk, err = p.read()
+	tk, err := p.read()
 
 	if err != nil {
 		return nil, err
@@ -356,6 +468,8 @@ func (p *Parser) parseFunc() (Node, error) {
 		}
 	}
 
+	elems := make([]Node, 0)
+
 	for {
 		done := false
 
@@ -368,21 +482,19 @@ func (p *Parser) parseFunc() (Node, error) {
 		switch tk.Type {
 		case TT_RBRACKET:
 			done = true
-		case TT_LPAREN:
+		case TT_LITINT, TT_LITFLOAT, TT_LITBOOL, TT_LITSTRING, TT_IDENT, TT_QUOT:
 			p.unread(tk)
-
-			arg, err := p.parseArg()
+			node, err := p.parseData()
 
 			if err != nil {
 				return nil, err
 			}
 
-			args[aj] = arg
-			aj++
+			elems = append(elems, node)
 		default:
 			return nil, &ParserError{
 				Token: tk,
-				Msg:   fmt.Sprintf("Expected `(` or `)` but got `%s`", tk.SVal),
+				Msg:   fmt.Sprintf("Expected literal, identifier, `'` or `]` but got `%s`.", tk.SVal),
 			}
 		}
 
@@ -391,21 +503,63 @@ func (p *Parser) parseFunc() (Node, error) {
 		}
 	}
 
-	// Then return types...
-	tk, err = p.read()
+	switch typ.(type) {
+	case *ListType:
+		return &LitListNode{
+			Type:  typ.(*ListType),
+			Elems: elems,
+			Token: firsttk,
+		}, nil
+	case *MapType:
+		if len(elems)%2 != 0 {
+			return nil, &ParserError{
+				Token: firsttk,
+				Msg:   "Map literal has a key without a value.",
+			}
+		}
+
+		keys := make([]Node, 0, len(elems)/2)
+		values := make([]Node, 0, len(elems)/2)
+
+		for i := 0; i < len(elems); i += 2 {
+			keys = append(keys, elems[i])
+			values = append(values, elems[i+1])
+		}
+
+		return &LitMapNode{
+			Type:   typ.(*MapType),
+			Keys:   keys,
+			Values: values,
+			Token:  firsttk,
+		}, nil
+	}
+
+	panic("BUG: not a collection type?")
+}
+
+// parseFuncType parses the part of a function type following the
+// `func` keyword which is the argument types and the return types
+// separated by `:` in curly brackets (e.g. `func{int float : int}`).
+func (p *Parser) parseFuncType(functk *Token) (Type, error) {
+	tk, err := p.read()
 
 	if err != nil {
-		return nil, err
+		return InvalidType, err
 	}
 
-	if tk.Type != TT_LBRACKET {
-		return nil, &ParserError{
+	if tk.Type != TT_LCBRACKET {
+		return InvalidType, &ParserError{
 			Token: tk,
-			Msg:   fmt.Sprintf("Expected `[` but got `%s`.", tk.SVal),
+			Msg:   fmt.Sprintf("Expected `{` but got `%s`.", tk.SVal),
 		}
 	}
 
-	rets := make([]Type, 0, 1)
+	argTypes := make([]Type, 0)
+	retTypes := make([]Type, 0)
+
+	// Argument types go into argTypes until the `:` is seen.
+	types := &argTypes
+	seenColon := false
 
 	for {
 		done := false
@@ -413,23 +567,36 @@ func (p *Parser) parseFunc() (Node, error) {
 		tk, err = p.read()
 
 		if err != nil {
-			return nil, err
+			return InvalidType, err
 		}
 
 		switch tk.Type {
-		case TT_RBRACKET:
-			done = true
+		case TT_COLON:
+			if seenColon {
+				return InvalidType, &ParserError{
+					Token: tk,
+					Msg:   "Unexpected second `:` in function type.",
+				}
+			}
 
-		default:
+			seenColon = true
+			types = &retTypes
+		case TT_RCBRACKET:
+			done = true
+		case TT_IDENT, TT_LCBRACKET, TT_FUNC:
 			p.unread(tk)
-
-			ret, err := p.parseType()
+			typ, err := p.parseType()
 
 			if err != nil {
-				return nil, err
+				return InvalidType, err
 			}
 
-			rets = append(rets, ret)
+			*types = append(*types, typ)
+		default:
+			return InvalidType, &ParserError{
+				Token: tk,
+				Msg:   fmt.Sprintf("Expected type, `:` or `}` but got `%s`.", tk.SVal),
+			}
 		}
 
 		if done {
@@ -437,112 +604,951 @@ func (p *Parser) parseFunc() (Node, error) {
 		}
 	}
 
-	tk, err = p.read()
-
-	if tk.Type != TT_LCBRACKET {
-		return nil, &ParserError{
-			Token: tk,
-			Msg:   fmt.Sprintf("Expected `{` but got `%s`.", tk.SVal),
+	if !seenColon {
+		return InvalidType, &ParserError{
+			Token: functk,
+			Msg:   "Function type is missing `:` between argument and return types.",
 		}
 	}
 
-	bodies := make([]Node, 8) // TODO: resize later
-	bj := 0
+	return &FuncType{
+		ArgTypes: argTypes,
+		RetTypes: retTypes,
+	}, nil
+}
 
-	for {
-		done := false
+func (p *Parser) Funcs() ([]*FuncNode, error) {
+	root, err := p.parseRoot()
 
-		tk, err = p.read()
+	if err != nil {
+		return nil, err
+	}
+
+	return root.Funcs, nil
+}
+
+func (p *Parser) Root() (*RootNode, error) {
+	return p.parseRoot()
+}
+
+func (p *Parser) parseRoot() (*RootNode, error) {
+	funcs := make([]*FuncNode, 0)
+	typeDecls := make(map[string]*TypeDeclNode)
+	imports := make([]*ImportNode, 0)
+	consts := make([]*ConstNode, 0)
+
+	for {
+		tk, err := p.read()
 
 		if err != nil {
 			return nil, err
 		}
 
+		if tk.Type == TT_EOF {
+			break
+		}
+
+		// The doc comment of a function precedes `pub` if present.
+		doc := p.docs[tk]
+
+		// `pub` makes the following function visible to other
+		// modules. On a type it makes the constructor, getters and
+		// setters of the record visible. The name of the type can be
+		// used by other modules either way.
+		pub := false
+
+		if tk.Type == TT_PUB {
+			pub = true
+			tk, err = p.read()
+
+			if err != nil {
+				return nil, err
+			}
+
+			if tk.Type != TT_FUNC && tk.Type != TT_TYPE && tk.Type != TT_CONST {
+				return nil, &ParserError{
+					Token: tk,
+					Msg:   fmt.Sprintf("Expected `func`, `type` or `const` after `pub` but got `%s`.", tk.SVal),
+				}
+			}
+		}
+
 		switch tk.Type {
-		case TT_RCBRACKET:
-			done = true
-		case TT_IF:
+		case TT_IMPORT:
+			// Imports must come first because they apply to the
+			// code following them.
+			if pub || len(funcs) > 0 || len(typeDecls) > 0 || len(consts) > 0 {
+				return nil, &ParserError{
+					Token: tk,
+					Msg:   "Imports must come before functions and types.",
+				}
+			}
+
 			p.unread(tk)
 
-			ifn, err := p.parseIf()
+			imp, err := p.parseImport()
 
 			if err != nil {
 				return nil, err
 			}
 
-			bodies[bj] = ifn
-			bj++
-		default:
+			imports = append(imports, imp)
+		case TT_FUNC:
 			p.unread(tk)
 
-			sexp, err := p.parseExp()
+			fn, err := p.parseFunc()
 
 			if err != nil {
 				return nil, err
 			}
 
-			bodies[bj] = sexp
-			bj++
-		}
-
-		if done {
-			break
-		}
-	}
+			fn_, ok := fn.(*FuncNode)
 
-	return &FuncNode{
-		Args:     args[:aj],
-		RetTypes: rets,
-		Body:     bodies[:bj],
-		Token:    firsttk,
-		Name:     funcname,
-	}, nil
-}
+			if !ok {
+				panic("BUG: didn't get *FuncNode")
+			}
 
-func (p *Parser) parseIf() (Node, error) {
-	panic("BUG")
-}
+			fn_.Pub = pub
+			fn_.Doc = doc
+			funcs = append(funcs, fn_)
+		case TT_TYPE:
+			p.unread(tk)
 
-func (p *Parser) parseExp() (Node, error) {
-	var firsttk *Token = nil
+			td, err := p.parseTypeDecl()
 
-	nodes := make([]Node, 8) //TODO: resize this later
-	nj := 0
+			if err != nil {
+				return nil, err
+			}
 
-	for {
-		tk, err := p.read()
+			td_, ok := td.(*TypeDeclNode)
 
-		if firsttk == nil {
-			firsttk = tk
-		}
+			if !ok {
+				panic("BUG: didn't get *TypeDeclNode")
+			}
 
-		if err != nil {
-			return nil, err
-		}
+			if typeDecls[td_.Name] != nil {
+				return nil, &ParserError{
+					Token: td_.Token,
+					Msg:   fmt.Sprintf("Duplicate type `%s`.", td_.Name),
+				}
+			}
 
-		switch tk.Type {
-		case TT_LITINT, TT_LITFLOAT, TT_IDENT, TT_QUOT:
+			td_.Pub = pub
+			typeDecls[td_.Name] = td_
+		case TT_CONST:
 			p.unread(tk)
-			node, err := p.parseData()
+
+			cn, err := p.parseConst()
 
 			if err != nil {
 				return nil, err
 			}
 
-			nodes[nj] = node
-			nj++
-		case TT_SEMICOLON:
-			return &ExpNode{
-				Exps:  nodes[:nj],
-				Token: firsttk,
-			}, nil
+			cn.Pub = pub
+			consts = append(consts, cn)
 		default:
 			return nil, &ParserError{
 				Token: tk,
-				Msg:   fmt.Sprintf("Expected literal, identifier, `;` or `'` but got `%s`.", tk.SVal),
+				Msg:   fmt.Sprintf("Expected `func`, `type`, `const`, `pub` or `import` but got `%s`.", tk.SVal),
 			}
 		}
 	}
 
-	return nil, nil
+	return &RootNode{
+		Funcs:     funcs,
+		TypeDecls: typeDecls,
+		Imports:   imports,
+		Consts:    consts,
+	}, nil
+}
+
+// parseConst parses a constant such as `const pi 3.14`.
+func (p *Parser) parseConst() (*ConstNode, error) {
+	// next token must be CONST
+
+	tk, err := p.read()
+
+	if err != nil {
+		return nil, err
+	}
+
+	firsttk := tk
+
+	if tk.Type != TT_CONST {
+		return nil, &ParserError{
+			Token: tk,
+			Msg:   fmt.Sprintf("Expected `const` but got `%s`.", tk.SVal),
+		}
+	}
+
+	tk, err = p.read()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if tk.Type != TT_IDENT {
+		return nil, &ParserError{
+			Token: tk,
+			Msg:   fmt.Sprintf("Expected identifier but got `%s`.", tk.SVal),
+		}
+	}
+
+	if strings.ContainsRune(tk.SVal, ':') {
+		return nil, &ParserError{
+			Token: tk,
+			Msg:   fmt.Sprintf("`:` is not allowed in identifiers in this context. Offending identifier is `%s`.", tk.SVal),
+		}
+	}
+
+	name := tk.SVal
+
+	value, err := p.parseData()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !isLiteral(value) {
+		return nil, &ParserError{
+			Token: firsttk,
+			Msg:   fmt.Sprintf("The value of constant `%s` must be a literal.", name),
+		}
+	}
+
+	return &ConstNode{
+		Name:  name,
+		Value: value,
+		Token: firsttk,
+	}, nil
+}
+
+// isLiteral returns true if the node is a literal. Collection literals
+// must only contain literals.
+func isLiteral(node Node) bool {
+	switch node.(type) {
+	case *LitIntNode, *LitFloatNode, *LitBoolNode, *LitStringNode:
+		return true
+	case *LitListNode:
+		for _, elem := range node.(*LitListNode).Elems {
+			if !isLiteral(elem) {
+				return false
+			}
+		}
+
+		return true
+	case *LitMapNode:
+		lit := node.(*LitMapNode)
+
+		for i := range lit.Keys {
+			if !isLiteral(lit.Keys[i]) || !isLiteral(lit.Values[i]) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// parseImport parses an import such as `import long.name`,
+// `import long.name as ln` or `import long.name (f g)`.
+func (p *Parser) parseImport() (*ImportNode, error) {
+	// next token must be IMPORT
+
+	tk, err := p.read()
+
+	if err != nil {
+		return nil, err
+	}
+
+	firsttk := tk
+
+	if tk.Type != TT_IMPORT {
+		return nil, &ParserError{
+			Token: tk,
+			Msg:   fmt.Sprintf("Expected `import` but got `%s`.", tk.SVal),
+		}
+	}
+
+	tk, err = p.read()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if tk.Type != TT_IDENT || !isName(tk.SVal) {
+		return nil, &ParserError{
+			Token: tk,
+			Msg:   fmt.Sprintf("Expected module name but got `%s`.", tk.SVal),
+		}
+	}
+
+	imp := &ImportNode{
+		Module:    tk.SVal,
+		Alias:     tk.SVal,
+		Token:     firsttk,
+		usedNames: make(map[string]bool),
+	}
+
+	tk, err = p.read()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if tk.Type == TT_IDENT && tk.SVal == "as" {
+		tk, err = p.read()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if tk.Type != TT_IDENT || !isName(tk.SVal) {
+			return nil, &ParserError{
+				Token: tk,
+				Msg:   fmt.Sprintf("Expected alias but got `%s`.", tk.SVal),
+			}
+		}
+
+		imp.Alias = tk.SVal
+
+		tk, err = p.read()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if tk.Type == TT_LPAREN {
+		for {
+			tk, err = p.read()
+
+			if err != nil {
+				return nil, err
+			}
+
+			if tk.Type == TT_RPAREN {
+				break
+			}
+
+			if tk.Type != TT_IDENT || !isName(tk.SVal) {
+				return nil, &ParserError{
+					Token: tk,
+					Msg:   fmt.Sprintf("Expected name or `)` but got `%s`.", tk.SVal),
+				}
+			}
+
+			if p.selected[tk.SVal] != nil {
+				return nil, &ParserError{
+					Token: tk,
+					Msg:   fmt.Sprintf("`%s` is imported twice.", tk.SVal),
+				}
+			}
+
+			p.selected[tk.SVal] = imp
+			imp.Names = append(imp.Names, tk.SVal)
+		}
+	} else {
+		p.unread(tk)
+	}
+
+	if p.aliases[imp.Alias] != nil {
+		return nil, &ParserError{
+			Token: firsttk,
+			Msg:   fmt.Sprintf("Duplicate import of `%s`.", imp.Alias),
+		}
+	}
+
+	p.aliases[imp.Alias] = imp
+
+	return imp, nil
+}
+
+// parseTypeDecl parses the declaration of a record type such as
+// `type point { x: float y: float }`.
+func (p *Parser) parseTypeDecl() (Node, error) {
+	// next token must be TYPE
+
+	tk, err := p.read()
+
+	if err != nil {
+		return nil, err
+	}
+
+	firsttk := tk
+
+	if tk.Type != TT_TYPE {
+		return nil, &ParserError{
+			Token: tk,
+			Msg:   fmt.Sprintf("Expected `type` but got `%s`.", tk.SVal),
+		}
+	}
+
+	// then the next token must be IDENT
+
+	tk, err = p.read()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if tk.Type != TT_IDENT {
+		return nil, &ParserError{
+			Token: tk,
+			Msg:   fmt.Sprintf("Expected identifier but got `%s`.", tk.SVal),
+		}
+	}
+
+	typename := tk.SVal
+
+	if strings.ContainsRune(typename, ':') {
+		return nil, &ParserError{
+			Token: tk,
+			Msg:   fmt.Sprintf("`:` is not allowed in identifiers in this context. Offending identifier is `%s`.", tk.SVal),
+		}
+	}
+
+	tk, err = p.read()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if tk.Type != TT_LCBRACKET {
+		return nil, &ParserError{
+			Token: tk,
+			Msg:   fmt.Sprintf("Expected `{` but got `%s`.", tk.SVal),
+		}
+	}
+
+	// then the fields follow. Each field is a name followed by `:` and
+	// its type. Because `:` is allowed in identifiers `x:` is a single
+	// identifier.
+
+	fields := make([]Arg, 0)
+
+	for {
+		tk, err = p.read()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if tk.Type == TT_RCBRACKET {
+			break
+		}
+
+		if tk.Type != TT_IDENT {
+			return nil, &ParserError{
+				Token: tk,
+				Msg:   fmt.Sprintf("Expected identifier or `}` but got `%s`.", tk.SVal),
+			}
+		}
+
+		fieldtk := tk
+		fieldname := tk.SVal
+
+		if strings.HasSuffix(fieldname, ":") {
+			fieldname = fieldname[:len(fieldname)-1]
+		} else {
+			tk, err = p.read()
+
+			if err != nil {
+				return nil, err
+			}
+
+			if tk.Type != TT_COLON {
+				return nil, &ParserError{
+					Token: tk,
+					Msg:   fmt.Sprintf("Expected `:` but got `%s`.", tk.SVal),
+				}
+			}
+		}
+
+		if fieldname == "" || strings.ContainsAny(fieldname, ":.") {
+			return nil, &ParserError{
+				Token: fieldtk,
+				Msg:   fmt.Sprintf("`%s` is not a valid field name.", fieldtk.SVal),
+			}
+		}
+
+		for _, field := range fields {
+			if field.Name == fieldname {
+				return nil, &ParserError{
+					Token: fieldtk,
+					Msg:   fmt.Sprintf("Duplicate field `%s` in type `%s`.", fieldname, typename),
+				}
+			}
+		}
+
+		typ, err := p.parseType()
+
+		if err != nil {
+			return nil, err
+		}
+
+		fields = append(fields, Arg{
+			Name: fieldname,
+			Type: typ,
+		})
+	}
+
+	return &TypeDeclNode{
+		Name: typename,
+		Type: &RecordType{
+			Name:   typename,
+			Fields: fields,
+		},
+		Token: firsttk,
+	}, nil
+}
+
+func (p *Parser) parseFunc() (Node, error) {
+	// next token must be FUNC
+
+	tk, err := p.read()
+
+	firsttk := tk
+
+	if err != nil {
+		return nil, err
+	}
+
+	if tk.Type != TT_FUNC {
+		return nil, &ParserError{
+			Token: tk,
+			Msg:   fmt.Sprintf("Expected `func` but got `%s`.", tk.SVal),
+		}
+	}
+
+	// then the next token must be IDENT
+
+	tk, err = p.read()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if tk.Type != TT_IDENT {
+		return nil, &ParserError{
+			Token: tk,
+			Msg:   fmt.Sprintf("Expected identifier but got `%s`.", tk.SVal),
+		}
+	}
+
+	funcname := tk.SVal
+
+	if strings.ContainsRune(funcname, ':') {
+		return nil, &ParserError{
+			Token: tk,
+			Msg:   fmt.Sprintf("`:` is not allowed in identifiers in this context. Offending identifier is `%s`.", tk.SVal),
+		}
+	}
+
+	args := make([]Arg, 0)
+
+	// then the arguments follow. which is at least one LPAREN then until RPAREN
+	tk, err = p.read()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if tk.Type != TT_LBRACKET {
+		return nil, &ParserError{
+			Token: tk,
+			Msg:   fmt.Sprintf("Expected `[` but got `%s`.", tk.SVal),
+		}
+	}
+
+	for {
+		done := false
+
+		tk, err = p.read()
+
+		if err != nil {
+			return nil, err
+		}
+
+		switch tk.Type {
+		case TT_RBRACKET:
+			done = true
+		case TT_LPAREN:
+			p.unread(tk)
+
+			arg, err := p.parseArg()
+
+			if err != nil {
+				return nil, err
+			}
+
+			args = append(args, arg)
+		default:
+			return nil, &ParserError{
+				Token: tk,
+				Msg:   fmt.Sprintf("Expected `(` or `)` but got `%s`", tk.SVal),
+			}
+		}
+
+		if done {
+			break
+		}
+	}
+
+	// Then return types...
+	tk, err = p.read()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if tk.Type != TT_LBRACKET {
+		return nil, &ParserError{
+			Token: tk,
+			Msg:   fmt.Sprintf("Expected `[` but got `%s`.", tk.SVal),
+		}
+	}
+
+	rets := make([]Type, 0, 1)
+
+	for {
+		done := false
+
+		tk, err = p.read()
+
+		if err != nil {
+			return nil, err
+		}
+
+		switch tk.Type {
+		case TT_RBRACKET:
+			done = true
+
+		default:
+			p.unread(tk)
+
+			ret, err := p.parseType()
+
+			if err != nil {
+				return nil, err
+			}
+
+			rets = append(rets, ret)
+		}
+
+		if done {
+			break
+		}
+	}
+
+	p.args = make(map[string]bool)
+
+	for _, arg := range args {
+		p.args[arg.Name] = true
+	}
+
+	bodies, err := p.parseBlock()
+	p.args = nil
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &FuncNode{
+		Args:     args,
+		RetTypes: rets,
+		Body:     bodies,
+		Token:    firsttk,
+		Name:     funcname,
+	}, nil
+}
+
+// parseBlock parses a sequence of expressions and ifs enclosed
+// in curly brackets.
+func (p *Parser) parseBlock() ([]Node, error) {
+	tk, err := p.read()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if tk.Type != TT_LCBRACKET {
+		return nil, &ParserError{
+			Token: tk,
+			Msg:   fmt.Sprintf("Expected `{` but got `%s`.", tk.SVal),
+		}
+	}
+
+	nodes := make([]Node, 0)
+
+	for {
+		tk, err = p.read()
+
+		if err != nil {
+			return nil, err
+		}
+
+		switch tk.Type {
+		case TT_RCBRACKET:
+			return nodes, nil
+		case TT_IF:
+			p.unread(tk)
+
+			ifn, err := p.parseIf()
+
+			if err != nil {
+				return nil, err
+			}
+
+			nodes = append(nodes, ifn)
+		case TT_MATCH:
+			p.unread(tk)
+
+			mn, err := p.parseMatch()
+
+			if err != nil {
+				return nil, err
+			}
+
+			nodes = append(nodes, mn)
+		default:
+			p.unread(tk)
+
+			sexp, err := p.parseExp()
+
+			if err != nil {
+				return nil, err
+			}
+
+			nodes = append(nodes, sexp)
+		}
+	}
+}
+
+func (p *Parser) parseIf() (Node, error) {
+	// next token must be IF
+
+	tk, err := p.read()
+
+	if err != nil {
+		return nil, err
+	}
+
+	firsttk := tk
+
+	if tk.Type != TT_IF {
+		return nil, &ParserError{
+			Token: tk,
+			Msg:   fmt.Sprintf("Expected `if` but got `%s`.", tk.SVal),
+		}
+	}
+
+	// then the condition follows which runs until the `{` of the
+	// then block.
+
+	conds := make([]Node, 0)
+	var condtk *Token = nil
+
+	for {
+		done := false
+
+		tk, err = p.read()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if condtk == nil {
+			condtk = tk
+		}
+
+		switch tk.Type {
+		case TT_LITINT, TT_LITFLOAT, TT_LITBOOL, TT_LITSTRING, TT_IDENT, TT_QUOT:
+			p.unread(tk)
+			node, err := p.parseData()
+
+			if err != nil {
+				return nil, err
+			}
+
+			conds = append(conds, node)
+		case TT_LCBRACKET:
+			p.unread(tk)
+			done = true
+		default:
+			return nil, &ParserError{
+				Token: tk,
+				Msg:   fmt.Sprintf("Expected literal, identifier, `'` or `{` but got `%s`.", tk.SVal),
+			}
+		}
+
+		if done {
+			break
+		}
+	}
+
+	if len(conds) == 0 {
+		return nil, &ParserError{
+			Token: condtk,
+			Msg:   "Missing condition of `if`.",
+		}
+	}
+
+	thenBlock, err := p.parseBlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	// the else block is optional
+
+	elseBlock := make([]Node, 0)
+
+	tk, err = p.read()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if tk.Type == TT_ELSE {
+		elseBlock, err = p.parseBlock()
+
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		p.unread(tk)
+	}
+
+	return &IfElseNode{
+		Condition: &ExpNode{
+			Exps:  conds,
+			Token: condtk,
+		},
+		ThenBlock: thenBlock,
+		ElseBlock: elseBlock,
+		Token:     firsttk,
+	}, nil
+}
+
+func (p *Parser) parseMatch() (Node, error) {
+	// next token must be MATCH
+
+	tk, err := p.read()
+
+	if err != nil {
+		return nil, err
+	}
+
+	firsttk := tk
+
+	if tk.Type != TT_MATCH {
+		return nil, &ParserError{
+			Token: tk,
+			Msg:   fmt.Sprintf("Expected `match` but got `%s`.", tk.SVal),
+		}
+	}
+
+	tk, err = p.read()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if tk.Type != TT_LCBRACKET {
+		return nil, &ParserError{
+			Token: tk,
+			Msg:   fmt.Sprintf("Expected `{` but got `%s`.", tk.SVal),
+		}
+	}
+
+	// then the cases follow. Each case is a type followed by a block.
+
+	cases := make([]*MatchCase, 0)
+
+	for {
+		tk, err = p.read()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if tk.Type == TT_RCBRACKET {
+			break
+		}
+
+		p.unread(tk)
+
+		typ, err := p.parseType()
+
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := p.parseBlock()
+
+		if err != nil {
+			return nil, err
+		}
+
+		cases = append(cases, &MatchCase{
+			Type:  typ,
+			Body:  body,
+			Token: tk,
+		})
+	}
+
+	if len(cases) == 0 {
+		return nil, &ParserError{
+			Token: firsttk,
+			Msg:   "`match` needs at least one case.",
+		}
+	}
+
+	return &MatchNode{
+		Cases: cases,
+		Token: firsttk,
+	}, nil
+}
+
+func (p *Parser) parseExp() (Node, error) {
+	var firsttk *Token = nil
+
+	nodes := make([]Node, 0)
+
+	for {
+		tk, err := p.read()
+
+		if firsttk == nil {
+			firsttk = tk
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		switch tk.Type {
+		case TT_LITINT, TT_LITFLOAT, TT_LITBOOL, TT_LITSTRING, TT_IDENT, TT_QUOT:
+			p.unread(tk)
+			node, err := p.parseData()
+
+			if err != nil {
+				return nil, err
+			}
+
+			nodes = append(nodes, node)
+		case TT_SEMICOLON:
+			return &ExpNode{
+				Exps:  nodes,
+				Token: firsttk,
+			}, nil
+		default:
+			return nil, &ParserError{
+				Token: tk,
+				Msg:   fmt.Sprintf("Expected literal, identifier, `;` or `'` but got `%s`.", tk.SVal),
+			}
+		}
+	}
 }
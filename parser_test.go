@@ -1,6 +1,7 @@
 package gocat
 
 import (
+	"fmt"
 	"testing"
 )
 
@@ -12,7 +13,67 @@ func TestParseType(t *testing.T) {
 			&PrimType{Type: "float"},
 		})
 	checkParseType("{int float}", ut, t)
-	mustErrorParseType("{int {foo bar} float}", t)
+
+	// Nested union types are flattened.
+	nested, _ := NewUnionType(
+		[]Type{
+			&PrimType{Type: "bar"},
+			&PrimType{Type: "float"},
+			&PrimType{Type: "foo"},
+			&PrimType{Type: "int"},
+		})
+	checkParseType("{int {foo bar} float}", nested, t)
+	checkParseType("{{int} {foo {bar}} float}", nested, t)
+	mustErrorParseType("{int {int float}}", t)
+
+	ft := &FuncType{
+		ArgTypes: []Type{&PrimType{Type: "int"}, &PrimType{Type: "float"}},
+		RetTypes: []Type{&PrimType{Type: "int"}},
+	}
+	checkParseType("func{int float : int}", ft, t)
+	checkParseType("func{ : }", &FuncType{ArgTypes: []Type{}, RetTypes: []Type{}}, t)
+
+	withFunc, _ := NewUnionType([]Type{&PrimType{Type: "int"}, ft})
+	checkParseType("{func{int float : int} int}", withFunc, t)
+	checkParseType("{int {func{int float : int}}}", withFunc, t)
+
+	intList := &ListType{ElemType: &PrimType{Type: "int"}}
+	checkParseType("list<int>", intList, t)
+	checkParseType("list<list<int>>", &ListType{ElemType: intList}, t)
+	checkParseType("map<int list<int>>", &MapType{KeyType: &PrimType{Type: "int"}, ValueType: intList}, t)
+	checkParseType("list<{int float}>", &ListType{ElemType: ut}, t)
+	mustErrorParseType("list", t)
+	mustErrorParseType("list<>", t)
+	mustErrorParseType("list<int float>", t)
+	mustErrorParseType("map<int>", t)
+
+	mustErrorParseType("func{int}", t)
+	mustErrorParseType("func{int : : int}", t)
+	mustErrorParseType("func int", t)
+}
+
+func TestParseLongExp(t *testing.T) {
+	exps := make([]Node, 0)
+	code := ""
+
+	for i := 1; i <= 12; i++ {
+		exps = append(exps, &LitIntNode{Value: int64(i)})
+		code += fmt.Sprintf("%d ", i)
+	}
+
+	checkASTExp(code+";", &ExpNode{Exps: exps}, t)
+
+	p := NewParser(NewTokenizerString("func f [(a int) (b int) (c int) (d int) (e int) (f int) (g int) (h int) (i int)] [int] { a b c d e f g h i add add add add add add add add; }"))
+
+	fns, err := p.Funcs()
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	if len(fns[0].Args) != 9 || fns[0].Args[8].Name != "i" || len(fns[0].Body[0].(*ExpNode).Exps) != 17 {
+		t.Fatalf("Unexpected function: %+v", fns[0])
+	}
 }
 
 func TestParseExp(t *testing.T) {
@@ -33,6 +94,416 @@ func TestParseExp(t *testing.T) {
 		}, t)
 }
 
+func TestParseLitCollection(t *testing.T) {
+	checkASTExp(
+		"list<int>[1 2] map<int float>[1 1.0];",
+		&ExpNode{
+			Exps: []Node{
+				&LitListNode{
+					Type: &ListType{ElemType: &PrimType{Type: "int"}},
+					Elems: []Node{
+						&LitIntNode{
+							Value: 1,
+						},
+						&LitIntNode{
+							Value: 2,
+						},
+					},
+				},
+				&LitMapNode{
+					Type: &MapType{KeyType: &PrimType{Type: "int"}, ValueType: &PrimType{Type: "float"}},
+					Keys: []Node{
+						&LitIntNode{
+							Value: 1,
+						},
+					},
+					Values: []Node{
+						&LitFloatNode{
+							Value: 1.0,
+						},
+					},
+				},
+			},
+		}, t)
+
+	mustErrorParseExp("list<int> 1;", t)
+	mustErrorParseExp("list<int>[1;", t)
+	mustErrorParseExp("map<int float>[1];", t)
+}
+
+func TestParseIf(t *testing.T) {
+	checkASTIf(
+		"if true { 5; } else { 6; }",
+		&IfElseNode{
+			Condition: &ExpNode{
+				Exps: []Node{
+					&LitBoolNode{
+						Value: true,
+					},
+				},
+			},
+			ThenBlock: []Node{
+				&ExpNode{
+					Exps: []Node{
+						&LitIntNode{
+							Value: 5,
+						},
+					},
+				},
+			},
+			ElseBlock: []Node{
+				&ExpNode{
+					Exps: []Node{
+						&LitIntNode{
+							Value: 6,
+						},
+					},
+				},
+			},
+		}, t)
+
+	checkASTIf(
+		"if false true or { }",
+		&IfElseNode{
+			Condition: &ExpNode{
+				Exps: []Node{
+					&LitBoolNode{
+						Value: false,
+					},
+					&LitBoolNode{
+						Value: true,
+					},
+					&VerbNode{
+						Verb: "or",
+					},
+				},
+			},
+			ThenBlock: []Node{},
+			ElseBlock: []Node{},
+		}, t)
+
+	mustErrorParseIf("if { }", t)
+	mustErrorParseIf("if true { } else", t)
+}
+
+func TestParseMatch(t *testing.T) {
+	checkASTMatch(
+		"match { int { 5; } float { } }",
+		&MatchNode{
+			Cases: []*MatchCase{
+				&MatchCase{
+					Type: &PrimType{Type: "int"},
+					Body: []Node{
+						&ExpNode{
+							Exps: []Node{
+								&LitIntNode{
+									Value: 5,
+								},
+							},
+						},
+					},
+				},
+				&MatchCase{
+					Type: &PrimType{Type: "float"},
+					Body: []Node{},
+				},
+			},
+		}, t)
+
+	mustErrorParseMatch("match { }", t)
+	mustErrorParseMatch("match { int }", t)
+	mustErrorParseMatch("match int { }", t)
+}
+
+func TestParseTypeDecl(t *testing.T) {
+	checkTypeDecl("type point { x: float y: float }",
+		&RecordType{
+			Name: "point",
+			Fields: []Arg{
+				Arg{Name: "x", Type: &PrimType{Type: "float"}},
+				Arg{Name: "y", Type: &PrimType{Type: "float"}},
+			},
+		}, t)
+
+	checkTypeDecl("type line { from : point to: point }",
+		&RecordType{
+			Name: "line",
+			Fields: []Arg{
+				Arg{Name: "from", Type: &PrimType{Type: "point"}},
+				Arg{Name: "to", Type: &PrimType{Type: "point"}},
+			},
+		}, t)
+
+	checkTypeDecl("type unit { }", &RecordType{Name: "unit", Fields: []Arg{}}, t)
+
+	mustErrorTypeDecl("type point { x float }", t)
+	mustErrorTypeDecl("type point { x: float x: int }", t)
+	mustErrorTypeDecl("type point { p.x: float }", t)
+	mustErrorTypeDecl("type foo:point { }", t)
+	mustErrorTypeDecl("type { }", t)
+}
+
+func checkTypeDecl(code string, exp *RecordType, t *testing.T) {
+	p := NewParser(NewTokenizerString(code))
+
+	n, err := p.parseTypeDecl()
+
+	if err != nil {
+		t.Fatalf("Unexpected error for %s: %s.", code, err.Error())
+		return
+	}
+
+	td := n.(*TypeDeclNode)
+	rec := td.Type.(*RecordType)
+
+	if td.Name != exp.Name || rec.Name != exp.Name || len(rec.Fields) != len(exp.Fields) {
+		t.Fatalf("Got type %+v but wanted %+v for %s.", rec, exp, code)
+		return
+	}
+
+	for i := 0; i < len(rec.Fields); i++ {
+		if !ArgEqual(rec.Fields[i], exp.Fields[i]) {
+			t.Fatalf("Got field %+v but wanted %+v for %s.", rec.Fields[i], exp.Fields[i], code)
+			return
+		}
+	}
+}
+
+func mustErrorTypeDecl(code string, t *testing.T) {
+	p := NewParser(NewTokenizerString(code))
+
+	_, err := p.parseTypeDecl()
+
+	if err == nil {
+		t.Fatalf("Expected error but got none for: %s", code)
+		return
+	}
+}
+
+func TestParseRoot(t *testing.T) {
+	p := NewParser(NewTokenizerString("type point { x: int } func main [] [] { } type foo { }"))
+
+	root, err := p.Root()
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+		return
+	}
+
+	if len(root.Funcs) != 1 || len(root.TypeDecls) != 2 {
+		t.Fatalf("Expected 1 function and 2 types but got %d and %d.", len(root.Funcs), len(root.TypeDecls))
+		return
+	}
+
+	p = NewParser(NewTokenizerString("type point { } type point { }"))
+
+	_, err = p.Root()
+
+	if err == nil {
+		t.Fatalf("Expected error but got none.")
+		return
+	}
+}
+
+// Errors about tokens at the end of a line are reported on that line
+// and not on the next one.
+func TestParseErrorLine(t *testing.T) {
+	p := NewParser(NewTokenizerString("func main [] [] { }\nfoo\nfunc other [] [] { }"))
+
+	_, err := p.Root()
+
+	pe, ok := err.(*ParserError)
+
+	if !ok || pe.Token.SVal != "foo" || pe.Token.Pos.LineNumber != 2 {
+		t.Fatalf("Expected a parser error for `foo` on line 2 but got: %v", err)
+	}
+}
+
+func TestParseRootPub(t *testing.T) {
+	p := NewParser(NewTokenizerString("pub type point { } type line { } pub func main [] [] { } func helper [] [] { }"))
+
+	root, err := p.Root()
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+		return
+	}
+
+	if !root.TypeDecls["point"].Pub || root.TypeDecls["line"].Pub || !root.Funcs[0].Pub || root.Funcs[1].Pub {
+		t.Fatalf("Wrong visibility.")
+		return
+	}
+
+	for _, code := range []string{"pub", "pub pub func main [] [] { }", "pub main"} {
+		p = NewParser(NewTokenizerString(code))
+
+		_, err = p.Root()
+
+		if err == nil {
+			t.Fatalf("Expected error but got none for: %s", code)
+			return
+		}
+	}
+}
+
+func TestParseDoc(t *testing.T) {
+	code := `
+// Unrelated.
+
+// main is the entry point.
+//
+//   It does nothing.
+pub func main [] [] {
+	// Not a doc comment.
+}
+// helper helps.
+func helper [] [] { } // Not a doc comment either.
+func undocumented [] [] { }
+`
+	p := NewParser(NewTokenizerString(code))
+
+	root, err := p.Root()
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+		return
+	}
+
+	docs := []string{
+		"main is the entry point.\n\n  It does nothing.",
+		"helper helps.",
+		"",
+	}
+
+	for i, doc := range docs {
+		if root.Funcs[i].Doc != doc {
+			t.Fatalf("Expected doc %q but got %q for %s.", doc, root.Funcs[i].Doc, root.Funcs[i].Name)
+			return
+		}
+	}
+}
+
+func TestParseImport(t *testing.T) {
+	code := `
+import long.module.name as lm
+import geo (point origin)
+import other
+func f [(origin int) (p lm:rec)] [point] {
+	lm:f 'lm:g origin point other:h geo:x;
+}
+`
+	p := NewParser(NewTokenizerString(code))
+
+	root, err := p.Root()
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+		return
+	}
+
+	if len(root.Imports) != 3 || root.Imports[0].Alias != "lm" || root.Imports[0].Module != "long.module.name" ||
+		len(root.Imports[1].Names) != 2 || root.Imports[2].Alias != "other" {
+		t.Fatalf("Unexpected imports: %+v", root.Imports)
+		return
+	}
+
+	exp := &FuncNode{
+		Name: "f",
+		Args: []Arg{
+			{Name: "origin", Type: &PrimType{Type: "int"}},
+			{Name: "p", Type: &PrimType{Type: "long.module.name:rec"}},
+		},
+		RetTypes: []Type{&PrimType{Type: "geo:point"}},
+		Body: []Node{
+			&ExpNode{
+				Exps: []Node{
+					&VerbNode{Verb: "long.module.name:f"},
+					&QuotNode{Ident: "long.module.name:g"},
+					&VerbNode{Verb: "origin"},
+					&VerbNode{Verb: "geo:point"},
+					&VerbNode{Verb: "other:h"},
+					&VerbNode{Verb: "geo:x"},
+				},
+			},
+		},
+	}
+
+	if !ASTEqual(root.Funcs[0], exp) {
+		t.Fatalf("Unexpected function: %+v", root.Funcs[0])
+		return
+	}
+
+	if !root.Imports[0].used || root.Imports[1].usedNames["origin"] || !root.Imports[1].usedNames["point"] {
+		t.Fatalf("Wrong usage of imports.")
+		return
+	}
+
+	codes := []string{
+		"func f [] [] { } import geo",
+		"pub import geo",
+		"import geo import geo",
+		"import geo as g import other as g",
+		"import geo (f) import other (f)",
+		"import",
+		"import geo:x",
+		"import geo as",
+		"import geo (f",
+		"import geo (1)",
+	}
+
+	for _, code := range codes {
+		p = NewParser(NewTokenizerString(code))
+
+		_, err = p.Root()
+
+		if err == nil {
+			t.Fatalf("Expected error but got none for: %s", code)
+			return
+		}
+	}
+}
+
+func TestParseConst(t *testing.T) {
+	p := NewParser(NewTokenizerString(`const pi 3.14 pub const names list<string>["a" "b"] const m map<int bool>[1 true]`))
+
+	root, err := p.Root()
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+		return
+	}
+
+	if len(root.Consts) != 3 || root.Consts[0].Name != "pi" || root.Consts[0].Pub || !root.Consts[1].Pub {
+		t.Fatalf("Unexpected constants: %+v", root.Consts)
+		return
+	}
+
+	if !ASTEqual(root.Consts[0].Value, &LitFloatNode{Value: 3.14}) {
+		t.Fatalf("Unexpected value: %+v", root.Consts[0].Value)
+		return
+	}
+
+	codes := []string{
+		"const pi",
+		"const pi square.i",
+		"const 1 1",
+		"const m:pi 3.14",
+		"const l list<int>[1 x]",
+		"const q 'f",
+	}
+
+	for _, code := range codes {
+		p = NewParser(NewTokenizerString(code))
+
+		_, err = p.Root()
+
+		if err == nil {
+			t.Fatalf("Expected error but got none for: %s", code)
+			return
+		}
+	}
+}
+
 func TestParseFunc(t *testing.T) {
 
 	checkASTFunc(
@@ -82,6 +553,67 @@ func checkASTFunc(code string, exp Node, t *testing.T) {
 	}
 }
 
+func checkASTIf(code string, exp Node, t *testing.T) {
+	p := NewParser(NewTokenizerString(code))
+
+	n, err := p.parseIf()
+
+	if err != nil {
+		t.Fatalf("Unexpected error for %s: %s.", code, err.Error())
+	}
+
+	if !ASTEqual(n, exp) {
+		t.Fatalf("ASTs do not match for %s! %+v %+v", code, n, exp)
+	}
+}
+
+func mustErrorParseIf(code string, t *testing.T) {
+	p := NewParser(NewTokenizerString(code))
+
+	_, err := p.parseIf()
+
+	if err == nil {
+		t.Fatalf("Expected error but got none for: %s", code)
+		return
+	}
+}
+
+func checkASTMatch(code string, exp Node, t *testing.T) {
+	p := NewParser(NewTokenizerString(code))
+
+	n, err := p.parseMatch()
+
+	if err != nil {
+		t.Fatalf("Unexpected error for %s: %s.", code, err.Error())
+	}
+
+	if !ASTEqual(n, exp) {
+		t.Fatalf("ASTs do not match for %s! %+v %+v", code, n, exp)
+	}
+}
+
+func mustErrorParseMatch(code string, t *testing.T) {
+	p := NewParser(NewTokenizerString(code))
+
+	_, err := p.parseMatch()
+
+	if err == nil {
+		t.Fatalf("Expected error but got none for: %s", code)
+		return
+	}
+}
+
+func mustErrorParseExp(code string, t *testing.T) {
+	p := NewParser(NewTokenizerString(code))
+
+	_, err := p.parseExp()
+
+	if err == nil {
+		t.Fatalf("Expected error but got none for: %s", code)
+		return
+	}
+}
+
 func checkASTExp(code string, exp Node, t *testing.T) {
 	p := NewParser(NewTokenizerString(code))
 
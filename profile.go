@@ -0,0 +1,385 @@
+package gocat
+
+import (
+	"compress/gzip"
+	"io"
+	"time"
+)
+
+// DefaultProfileRate is the number of steps between two samples of a
+// new profiler.
+const DefaultProfileRate = 100
+
+// Profiler samples the gocat call stack of a runtime every rate steps.
+// A sample accounts for the steps and the wall time since the previous
+// sample including the time spent in builtins and host functions. Steps
+// after the last sample of a profile are not accounted for.
+type Profiler struct {
+	rate uint64
+
+	// nodes are the call stacks sampled so far in the order they were
+	// first seen. A call stack is identified by its innermost node.
+	nodes map[profKey]*profNode
+	order []*profNode
+
+	// pending and pendingNanos are the steps and the wall time since
+	// the last sample. lastAt is zero outside of executions.
+	pending      uint64
+	pendingNanos int64
+	lastAt       time.Time
+
+	start    time.Time
+	duration time.Duration
+}
+
+// profKey identifies a frame of a call stack by its caller, its
+// function and the verb being executed.
+type profKey struct {
+	parent *profNode
+	fn     *Func
+	pos    *FilePos
+}
+
+// profNode is a call stack together with the number of steps and the
+// wall time of its samples.
+type profNode struct {
+	parent *profNode
+	frame  profFrame
+	steps  int64
+	nanos  int64
+}
+
+// profFrame is a frame of a call stack. line is the line of the verb
+// being executed.
+type profFrame struct {
+	fqname    string
+	filePath  string
+	startLine int64
+	line      int64
+}
+
+func NewProfiler() *Profiler {
+	return &Profiler{
+		rate:  DefaultProfileRate,
+		nodes: make(map[profKey]*profNode),
+	}
+}
+
+// SetRate sets the number of steps between two samples. A rate of 1
+// samples every step.
+func (p *Profiler) SetRate(rate uint64) {
+	if rate == 0 {
+		rate = 1
+	}
+
+	p.rate = rate
+}
+
+// SetProfiler makes subsequent executions sample their call stacks
+// into prof. A nil prof disables profiling.
+func (rt *Runtime) SetProfiler(prof *Profiler) {
+	rt.prof = prof
+}
+
+// step accounts for a step executed with the call stack frames.
+func (p *Profiler) step(frames []*frame) {
+	if p.lastAt.IsZero() {
+		p.lastAt = time.Now()
+
+		if p.start.IsZero() {
+			p.start = p.lastAt
+		}
+	}
+
+	p.pending++
+
+	if p.pending < p.rate || len(frames) == 0 {
+		return
+	}
+
+	now := time.Now()
+	elapsed := now.Sub(p.lastAt)
+
+	node := p.node(frames)
+	node.steps += int64(p.pending)
+	node.nanos += p.pendingNanos + int64(elapsed)
+
+	p.duration += elapsed
+	p.pending = 0
+	p.pendingNanos = 0
+	p.lastAt = now
+}
+
+// node returns the node of the call stack frames.
+func (p *Profiler) node(frames []*frame) *profNode {
+	var node *profNode
+
+	for _, fr := range frames {
+		key := profKey{parent: node, fn: fr.fn, pos: fr.pos}
+		child := p.nodes[key]
+
+		if child == nil {
+			pos := fr.fn.FuncNode.Token.Pos
+
+			child = &profNode{
+				parent: node,
+				frame: profFrame{
+					fqname:    fr.fqname,
+					filePath:  pos.FilePath,
+					startLine: int64(pos.LineNumber),
+					line:      int64(pos.LineNumber),
+				},
+			}
+
+			if fr.pos != nil {
+				child.frame.line = int64(fr.pos.LineNumber)
+			}
+
+			p.nodes[key] = child
+			p.order = append(p.order, child)
+		}
+
+		node = child
+	}
+
+	return node
+}
+
+// stop ends an execution. The time since the last sample is accounted
+// for by the next sample.
+func (p *Profiler) stop() {
+	if p.lastAt.IsZero() {
+		return
+	}
+
+	elapsed := time.Since(p.lastAt)
+	p.pendingNanos += int64(elapsed)
+	p.duration += elapsed
+	p.lastAt = time.Time{}
+}
+
+// WriteProfile writes the profile in the gzipped protocol buffer format
+// of pprof. The samples have the values `steps` and `wall`.
+func (p *Profiler) WriteProfile(w io.Writer) error {
+	b := &profileBuilder{
+		strings:   map[string]int64{"": 0},
+		table:     []string{""},
+		functions: make(map[string]uint64),
+		locations: make(map[profFrame]uint64),
+	}
+
+	var prof protoBuffer
+
+	prof.message(1, b.valueType("steps", "count"))
+	prof.message(1, b.valueType("wall", "nanoseconds"))
+
+	for _, node := range p.order {
+		if node.steps == 0 {
+			continue
+		}
+
+		// The innermost frame comes first like in pprof.
+		var locs []uint64
+
+		for n := node; n != nil; n = n.parent {
+			locs = append(locs, b.location(n.frame))
+		}
+
+		var msg protoBuffer
+		msg.packedUints(1, locs)
+		msg.packedInts(2, []int64{node.steps, node.nanos})
+		prof.message(2, &msg)
+	}
+
+	var mapping protoBuffer
+	mapping.uint(1, 1)
+	mapping.int(5, b.str("gocat"))
+	mapping.bool(7, true)
+	mapping.bool(8, true)
+	mapping.bool(9, true)
+	prof.message(3, &mapping)
+
+	prof.raw(b.locs.bytes())
+	prof.raw(b.funcs.bytes())
+
+	// The string table is complete once all other messages are built.
+	periodType := b.valueType("steps", "count")
+	defaultType := b.str("wall")
+
+	for _, s := range b.table {
+		prof.string(6, s)
+	}
+
+	prof.int(9, p.start.UnixNano())
+	prof.int(10, int64(p.duration))
+	prof.message(11, periodType)
+	prof.int(12, int64(p.rate))
+	prof.int(14, defaultType)
+
+	zw := gzip.NewWriter(w)
+
+	_, err := zw.Write(prof.bytes())
+
+	if err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+// profileBuilder builds the string table, functions and locations of a
+// profile.
+type profileBuilder struct {
+	strings map[string]int64
+	table   []string
+
+	functions map[string]uint64
+	funcs     protoBuffer
+
+	locations map[profFrame]uint64
+	locs      protoBuffer
+}
+
+func (b *profileBuilder) str(s string) int64 {
+	i, ok := b.strings[s]
+
+	if !ok {
+		i = int64(len(b.table))
+		b.strings[s] = i
+		b.table = append(b.table, s)
+	}
+
+	return i
+}
+
+func (b *profileBuilder) valueType(typ string, unit string) *protoBuffer {
+	var msg protoBuffer
+	msg.int(1, b.str(typ))
+	msg.int(2, b.str(unit))
+	return &msg
+}
+
+func (b *profileBuilder) function(pf profFrame) uint64 {
+	id, ok := b.functions[pf.fqname]
+
+	if ok {
+		return id
+	}
+
+	id = uint64(len(b.functions) + 1)
+	b.functions[pf.fqname] = id
+
+	var msg protoBuffer
+	msg.uint(1, id)
+	msg.int(2, b.str(pf.fqname))
+	msg.int(3, b.str(pf.fqname))
+	msg.int(4, b.str(pf.filePath))
+	msg.int(5, pf.startLine)
+	b.funcs.message(5, &msg)
+
+	return id
+}
+
+func (b *profileBuilder) location(pf profFrame) uint64 {
+	key := profFrame{fqname: pf.fqname, line: pf.line}
+	id, ok := b.locations[key]
+
+	if ok {
+		return id
+	}
+
+	id = uint64(len(b.locations) + 1)
+	b.locations[key] = id
+
+	var line protoBuffer
+	line.uint(1, b.function(pf))
+	line.int(2, pf.line)
+
+	var msg protoBuffer
+	msg.uint(1, id)
+	msg.uint(2, 1)
+	msg.message(4, &line)
+	b.locs.message(4, &msg)
+
+	return id
+}
+
+// protoBuffer encodes messages in the protocol buffer wire format.
+type protoBuffer struct {
+	buf []byte
+}
+
+func (pb *protoBuffer) bytes() []byte {
+	return pb.buf
+}
+
+func (pb *protoBuffer) raw(data []byte) {
+	pb.buf = append(pb.buf, data...)
+}
+
+func (pb *protoBuffer) varint(x uint64) {
+	for x >= 0x80 {
+		pb.buf = append(pb.buf, byte(x)|0x80)
+		x >>= 7
+	}
+
+	pb.buf = append(pb.buf, byte(x))
+}
+
+func (pb *protoBuffer) tag(field int, wireType int) {
+	pb.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+// uint writes a varint field. Zero values are omitted like in proto3.
+func (pb *protoBuffer) uint(field int, x uint64) {
+	if x == 0 {
+		return
+	}
+
+	pb.tag(field, 0)
+	pb.varint(x)
+}
+
+func (pb *protoBuffer) int(field int, x int64) {
+	pb.uint(field, uint64(x))
+}
+
+func (pb *protoBuffer) bool(field int, x bool) {
+	if x {
+		pb.uint(field, 1)
+	}
+}
+
+// string writes a string field. Empty strings are not omitted because
+// they are significant in repeated fields.
+func (pb *protoBuffer) string(field int, s string) {
+	pb.tag(field, 2)
+	pb.varint(uint64(len(s)))
+	pb.buf = append(pb.buf, s...)
+}
+
+func (pb *protoBuffer) message(field int, msg *protoBuffer) {
+	pb.tag(field, 2)
+	pb.varint(uint64(len(msg.buf)))
+	pb.buf = append(pb.buf, msg.buf...)
+}
+
+func (pb *protoBuffer) packedUints(field int, xs []uint64) {
+	var packed protoBuffer
+
+	for _, x := range xs {
+		packed.varint(x)
+	}
+
+	pb.message(field, &packed)
+}
+
+func (pb *protoBuffer) packedInts(field int, xs []int64) {
+	var packed protoBuffer
+
+	for _, x := range xs {
+		packed.varint(uint64(x))
+	}
+
+	pb.message(field, &packed)
+}
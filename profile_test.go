@@ -0,0 +1,151 @@
+package gocat
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+)
+
+const profileTestCode = `
+func main [] [int] {
+	list<int>[1 2 3] 'test:work each;
+	test:work.more;
+}
+
+func work [(x int)] [] {
+	x square.i test:drop;
+}
+
+func work.more [] [int] {
+	1 square.i;
+}
+
+func drop [(x int)] [] {
+}
+`
+
+func TestProfiler(t *testing.T) {
+	modules, err := loadTestModules(map[string]string{"test": profileTestCode})
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	prof := NewProfiler()
+	prof.SetRate(1)
+
+	rt := NewRuntime(modules)
+	rt.SetProfiler(prof)
+
+	for i := 0; i < 2; i++ {
+		_, err = rt.Exec("test:main", nil)
+
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+	}
+
+	steps := make(map[string]int64)
+	total := int64(0)
+
+	for _, node := range prof.order {
+		steps[node.frame.fqname] += node.steps
+		total += node.steps
+	}
+
+	// main executes the list literal with its three elements, the
+	// quotation, each and work.more. Each call of work executes x,
+	// square.i and drop.
+	exp := map[string]int64{
+		"test:main":      2 * 7,
+		"test:work":      2 * 3 * 3,
+		"test:work.more": 2 * 2,
+	}
+
+	if len(steps) != len(exp) {
+		t.Fatalf("Expected %v but got %v.", exp, steps)
+	}
+
+	for fqname, n := range exp {
+		if steps[fqname] != n {
+			t.Fatalf("Expected %v but got %v.", exp, steps)
+		}
+	}
+
+	var buf bytes.Buffer
+
+	err = prof.WriteProfile(&buf)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	zr, err := gzip.NewReader(&buf)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	data, err := io.ReadAll(zr)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	for _, s := range []string{"steps", "wall", "nanoseconds", "test:main", "test:work.more", "<memory>"} {
+		if !bytes.Contains(data, []byte(s)) {
+			t.Fatalf("Expected %q in the profile.", s)
+		}
+	}
+}
+
+func TestProfilerRate(t *testing.T) {
+	modules, err := loadTestModules(map[string]string{"test": profileTestCode})
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	prof := NewProfiler()
+	prof.SetRate(5)
+
+	rt := NewRuntime(modules)
+	rt.SetProfiler(prof)
+
+	for i := 0; i < 2; i++ {
+		_, err = rt.Exec("test:main", nil)
+
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+	}
+
+	// Samples are taken across executions. The last step of the 36
+	// steps is not sampled.
+	total := int64(0)
+
+	for _, node := range prof.order {
+		if node.steps%5 != 0 {
+			t.Fatalf("Expected a multiple of 5 steps but got %d.", node.steps)
+		}
+
+		total += node.steps
+	}
+
+	if total != 35 {
+		t.Fatalf("Expected 35 steps but got %d.", total)
+	}
+
+	// Sampling known call stacks does not allocate.
+	frames := []*frame{{fqname: "test:main", fn: modules["test"].Funcs["main"]}}
+	prof.step(frames)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		prof.step(frames)
+	})
+
+	if allocs != 0 {
+		t.Fatalf("Expected no allocations but got %v.", allocs)
+	}
+}
@@ -0,0 +1,66 @@
+package gocat
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// TestFileSuffix is the suffix of the names of the test files of a
+// module. Test files are only loaded by LoadTestModule and
+// LoadTestModuleFS. Their functions whose names start with TestPrefix
+// are tests.
+const TestFileSuffix = "_test.gct"
+
+// TestPrefix is the prefix of the names of test functions. Tests have
+// the type `func{ : }` and fail if they fail with a runtime error such
+// as a failed `assert.eq` or `assert.true`.
+const TestPrefix = "test_"
+
+// withoutTestFiles returns the paths that are not paths of test files.
+func withoutTestFiles(fpaths []string) []string {
+	var files []string
+
+	for _, fpath := range fpaths {
+		if !strings.HasSuffix(fpath, TestFileSuffix) {
+			files = append(files, fpath)
+		}
+	}
+
+	return files
+}
+
+// TestFuncs returns the sorted names of the tests of the module.
+func TestFuncs(module *Module) []string {
+	var names []string
+
+	for name, fn := range module.Funcs {
+		if strings.HasPrefix(name, TestPrefix) && fn.FuncNode != nil {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// checkTests checks that the tests of the modules have the type
+// `func{ : }`.
+func checkTests(modules map[string]*Module) error {
+	for _, name := range sortedNames(modules) {
+		for _, fname := range sortedNames(modules[name].Funcs) {
+			fn := modules[name].Funcs[fname]
+
+			if !strings.HasPrefix(fname, TestPrefix) {
+				continue
+			}
+
+			if fn.FuncNode == nil || len(fn.Type.ArgTypes) != 0 || len(fn.Type.RetTypes) != 0 {
+				return fmt.Errorf("Test `%s:%s` must be a function of type `func{ : }` but is of type `%s`.",
+					name, fname, fn.Type)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,117 @@
+package gocat
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadTestModuleFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"geo/geo.gct": &fstest.MapFile{
+			Data: []byte("func two [] [int] { 2; }\n"),
+		},
+		"geo/geo_test.gct": &fstest.MapFile{
+			Data: []byte("func test_two [] [] { two 2 assert.eq; }\nfunc helper [] [] { }\n"),
+		},
+	}
+
+	module, err := LoadModuleFS(fsys, "geo")
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	if module.Funcs["test_two"] != nil || len(TestFuncs(module)) != 0 {
+		t.Fatalf("Test files must not be loaded by LoadModuleFS.")
+	}
+
+	module, err = LoadTestModuleFS(fsys, "geo")
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	tests := TestFuncs(module)
+
+	if len(tests) != 1 || tests[0] != "test_two" {
+		t.Fatalf("Unexpected tests: %v", tests)
+	}
+}
+
+func TestAssert(t *testing.T) {
+	code := `
+type point { x: int y: int }
+
+func test_pass [] [] {
+	1 1 assert.eq;
+	"a" "a" assert.eq;
+	list<int>[1 2] list<int>[1 2] assert.eq;
+	map<string int>["a" 1] map<string int>["a" 1] assert.eq;
+	1 2 test:point 1 2 test:point assert.eq;
+	true assert.true;
+}
+
+func test_int [] [] {
+	1 2 assert.eq;
+}
+
+func test_list [] [] {
+	list<float>[1.0] list<float>[1.5] assert.eq;
+}
+
+func test_record [] [] {
+	1 2 test:point 1 3 test:point assert.eq;
+}
+
+func test_bool [] [] {
+	false assert.true;
+}
+`
+	modules, err := loadTestModules(map[string]string{"test": code})
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	checkExec(modules, "test:test_pass", nil, []Value{}, t)
+
+	failures := map[string]string{
+		"test:test_int":    "Assertion failed: 1 is not equal to 2.",
+		"test:test_list":   "Assertion failed: list<float>[1.0] is not equal to list<float>[1.5].",
+		"test:test_record": "Assertion failed: test:point{x: 1, y: 2} is not equal to test:point{x: 1, y: 3}.",
+		"test:test_bool":   "Assertion failed.",
+	}
+
+	for fqname, msg := range failures {
+		_, err := NewRuntime(modules).Exec(fqname, nil)
+
+		re, ok := err.(*RuntimeError)
+
+		if !ok || re.Msg != msg || re.Pos == nil {
+			t.Fatalf("Expected %q for %s but got: %v", msg, fqname, err)
+		}
+	}
+
+	codes := []string{
+		"func f [] [] { 1 \"1\" assert.eq; }",
+		"func f [] [] { 1 assert.eq; }",
+		"func f [] [] { 1 assert.true; }",
+		"func test_f [] [int] { 1; }",
+		"func test_f [(x int)] [] { }",
+	}
+
+	for _, code := range codes {
+		_, err := loadTestModules(map[string]string{"test": code})
+
+		if err == nil {
+			t.Fatalf("Expected an error but got none for: %s", code)
+		}
+	}
+
+	_, err = loadTestModules(map[string]string{"test": "func test_f [] [int] { 1; }"})
+
+	if err == nil || !strings.Contains(err.Error(), "Test `test:test_f` must be a function of type `func{ : }`") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
@@ -42,6 +42,17 @@ const TT_QUOT = TokenType(12)
 const TT_IF = TokenType(13)
 const TT_LBRACKET = TokenType(14)
 const TT_RBRACKET = TokenType(15)
+const TT_ELSE = TokenType(16)
+const TT_LITBOOL = TokenType(17)
+const TT_MATCH = TokenType(18)
+const TT_LANGLE = TokenType(19)
+const TT_RANGLE = TokenType(20)
+const TT_TYPE = TokenType(21)
+const TT_LITSTRING = TokenType(22)
+const TT_PUB = TokenType(23)
+const TT_IMPORT = TokenType(24)
+const TT_CONST = TokenType(25)
+const TT_COMMENT = TokenType(26)
 
 type Tokenizer interface {
 	Next() (*Token, error)
@@ -53,6 +64,10 @@ type tokenizer struct {
 	lineno uint32
 	charno uint32
 	rn     rune
+
+	// bol is true if only whitespace has been read since the start of
+	// the current line.
+	bol bool
 }
 
 func NewTokenizerReader(r io.Reader, fpath string) Tokenizer {
@@ -62,6 +77,7 @@ func NewTokenizerReader(r io.Reader, fpath string) Tokenizer {
 		lineno: 1,
 		charno: 1,
 		rn:     eof,
+		bol:    true,
 	}
 }
 
@@ -72,6 +88,7 @@ func NewTokenizerString(code string) Tokenizer {
 		lineno: 1,
 		charno: 1,
 		rn:     eof,
+		bol:    true,
 	}
 }
 
@@ -120,6 +137,7 @@ func (t *tokenizer) read() (rune, error) {
 	}
 
 	if rn == '\n' {
+		t.bol = true
 		t.lineno++
 		t.charno = 1
 	} else {
@@ -131,10 +149,24 @@ func (t *tokenizer) read() (rune, error) {
 	return rn, nil
 }
 
+// endpos returns the position of a token which ends at pos and was
+// terminated by reading rn. Positions point after the terminating rune
+// unless it is a newline which would move the token to the next line.
+func (t *tokenizer) endpos(pos *FilePos, rn rune) *FilePos {
+	if rn == '\n' {
+		pos.CharNumber++
+		return pos
+	}
+
+	return t.filepos()
+}
+
 func (t *tokenizer) litintfloat(rn rune) (*Token, error) {
 	var buf bytes.Buffer
 	buf.WriteRune(rn)
 
+	// pos is the position after the last rune of the literal.
+	pos := t.filepos()
 	seenDot := false
 
 	for {
@@ -150,6 +182,7 @@ func (t *tokenizer) litintfloat(rn rune) (*Token, error) {
 
 		if isdigit(rn) {
 			buf.WriteRune(rn)
+			pos = t.filepos()
 		} else if rn == '.' {
 			if seenDot {
 				return nil, &TokenizerError{
@@ -159,7 +192,9 @@ func (t *tokenizer) litintfloat(rn rune) (*Token, error) {
 			}
 			seenDot = true
 			buf.WriteRune(rn)
+			pos = t.filepos()
 		} else {
+			pos = t.endpos(pos, rn)
 			t.unread(rn)
 			break
 		}
@@ -178,22 +213,117 @@ func (t *tokenizer) litintfloat(rn rune) (*Token, error) {
 		return &Token{
 			SVal: str,
 			Type: TT_LITFLOAT,
-			Pos:  t.filepos(),
+			Pos:  pos,
 		}, nil
 
 	} else {
 		return &Token{
 			SVal: str,
 			Type: TT_LITINT,
-			Pos:  t.filepos(),
+			Pos:  pos,
 		}, nil
 	}
 }
 
+// litstring reads a string literal. The opening `"` has already been
+// read. Supported escape sequences are \", \\, \n and \t.
+func (t *tokenizer) litstring() (*Token, error) {
+	var buf bytes.Buffer
+
+	for {
+		rn, err := t.read()
+
+		if err != nil {
+			return nil, err
+		}
+
+		switch rn {
+		case eof:
+			return nil, &TokenizerError{
+				Pos: t.filepos(),
+				Err: fmt.Errorf("Unterminated string literal."),
+			}
+		case '"':
+			return &Token{
+				SVal: buf.String(),
+				Type: TT_LITSTRING,
+				Pos:  t.filepos(),
+			}, nil
+		case '\\':
+			rn, err = t.read()
+
+			if err != nil {
+				return nil, err
+			}
+
+			switch rn {
+			case '"', '\\':
+				buf.WriteRune(rn)
+			case 'n':
+				buf.WriteRune('\n')
+			case 't':
+				buf.WriteRune('\t')
+			default:
+				return nil, &TokenizerError{
+					Pos: t.filepos(),
+					Err: fmt.Errorf("Unknown escape sequence `\\%c` in string literal.", rn),
+				}
+			}
+		default:
+			buf.WriteRune(rn)
+		}
+	}
+}
+
+// comment reads a comment such as `// text` up to the end of the line.
+// The opening `/` has already been read. The text of the comment
+// excludes the `//`.
+func (t *tokenizer) comment() (*Token, error) {
+	pos := t.filepos()
+
+	rn, err := t.read()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if rn != '/' {
+		return nil, &TokenizerError{
+			Pos: t.filepos(),
+			Err: fmt.Errorf("Expected `//` but got `/%c`.", rn),
+		}
+	}
+
+	var buf bytes.Buffer
+
+	for {
+		rn, err := t.read()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if rn == eof || rn == '\n' {
+			break
+		}
+
+		buf.WriteRune(rn)
+	}
+
+	return &Token{
+		SVal: strings.TrimRight(buf.String(), "\r"),
+		Type: TT_COMMENT,
+		Pos:  pos,
+	}, nil
+}
+
 func (t *tokenizer) ident(rn rune) (*Token, error) {
 	var buf bytes.Buffer
 	buf.WriteRune(rn)
 
+	// pos is the position after the last rune of the identifier.
+	pos := t.filepos()
+
 	for {
 		rn, err := t.read()
 
@@ -207,7 +337,9 @@ func (t *tokenizer) ident(rn rune) (*Token, error) {
 
 		if isident(rn) {
 			buf.WriteRune(rn)
+			pos = t.filepos()
 		} else {
+			pos = t.endpos(pos, rn)
 			t.unread(rn)
 			break
 		}
@@ -220,14 +352,62 @@ func (t *tokenizer) ident(rn rune) (*Token, error) {
 		return &Token{
 			SVal: str,
 			Type: TT_FUNC,
-			Pos:  t.filepos(),
+			Pos:  pos,
+		}, nil
+	case "const":
+		return &Token{
+			SVal: str,
+			Type: TT_CONST,
+			Pos:  pos,
+		}, nil
+	case "import":
+		return &Token{
+			SVal: str,
+			Type: TT_IMPORT,
+			Pos:  pos,
+		}, nil
+	case "pub":
+		return &Token{
+			SVal: str,
+			Type: TT_PUB,
+			Pos:  pos,
+		}, nil
+	case "type":
+		return &Token{
+			SVal: str,
+			Type: TT_TYPE,
+			Pos:  pos,
+		}, nil
+	case "if":
+		return &Token{
+			SVal: str,
+			Type: TT_IF,
+			Pos:  pos,
+		}, nil
+	case "else":
+		return &Token{
+			SVal: str,
+			Type: TT_ELSE,
+			Pos:  pos,
+		}, nil
+	case "match":
+		return &Token{
+			SVal: str,
+			Type: TT_MATCH,
+			Pos:  pos,
+		}, nil
+	case "true", "false":
+		return &Token{
+			SVal: str,
+			Type: TT_LITBOOL,
+			Pos:  pos,
 		}, nil
 	}
 
 	return &Token{
 		SVal: str,
 		Type: TT_IDENT,
-		Pos:  t.filepos(),
+		Pos:  pos,
 	}, nil
 }
 
@@ -250,6 +430,9 @@ func (t *tokenizer) Next() (*Token, error) {
 		}
 	}
 
+	bol := t.bol
+	t.bol = false
+
 	switch rn {
 	case '#':
 		return &Token{
@@ -263,6 +446,12 @@ func (t *tokenizer) Next() (*Token, error) {
 			Type: TT_SEMICOLON,
 			Pos:  t.filepos(),
 		}, nil
+	case ':':
+		return &Token{
+			SVal: ":",
+			Type: TT_COLON,
+			Pos:  t.filepos(),
+		}, nil
 	case '{':
 		return &Token{
 			SVal: "{",
@@ -299,6 +488,18 @@ func (t *tokenizer) Next() (*Token, error) {
 			Type: TT_RPAREN,
 			Pos:  t.filepos(),
 		}, nil
+	case '<':
+		return &Token{
+			SVal: "<",
+			Type: TT_LANGLE,
+			Pos:  t.filepos(),
+		}, nil
+	case '>':
+		return &Token{
+			SVal: ">",
+			Type: TT_RANGLE,
+			Pos:  t.filepos(),
+		}, nil
 	case '\'':
 		return &Token{
 			SVal: "'",
@@ -307,7 +508,18 @@ func (t *tokenizer) Next() (*Token, error) {
 		}, nil
 	}
 
-	if isletter(rn) || rn == '%' {
+	if rn == '"' {
+		return t.litstring()
+	} else if rn == '/' {
+		tk, err := t.comment()
+
+		// Comments following code on the same line are skipped.
+		if err != nil || bol {
+			return tk, err
+		}
+
+		return t.Next()
+	} else if isletter(rn) || rn == '%' {
 		return t.ident(rn)
 	} else if isdigit(rn) || rn == '-' {
 		return t.litintfloat(rn)
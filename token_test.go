@@ -15,6 +15,44 @@ func TestTokenizerSpecials(t *testing.T) {
 	checkTypes("{}", []TokenType{TT_LCBRACKET, TT_RCBRACKET}, t)
 	checkTypes("func()", []TokenType{TT_FUNC, TT_LPAREN, TT_RPAREN}, t)
 	checkTypes(" ; ", []TokenType{TT_SEMICOLON}, t)
+	checkTypes("list<int>", []TokenType{TT_IDENT, TT_LANGLE, TT_IDENT, TT_RANGLE}, t)
+}
+
+func TestTokenizerKeywords(t *testing.T) {
+	checkTypes("if else", []TokenType{TT_IF, TT_ELSE}, t)
+	checkTypes("match", []TokenType{TT_MATCH}, t)
+	checkTypes("pub func", []TokenType{TT_PUB, TT_FUNC}, t)
+	checkTypes("import", []TokenType{TT_IMPORT}, t)
+	checkTypes("true false", []TokenType{TT_LITBOOL, TT_LITBOOL}, t)
+	checkTypes("truefalse", []TokenType{TT_IDENT}, t)
+}
+
+func TestTokenizerComments(t *testing.T) {
+	checkTypes("// comment", []TokenType{TT_COMMENT}, t)
+	checkTypes("// comment\nfunc", []TokenType{TT_COMMENT, TT_FUNC}, t)
+	checkTypes("  // a\n\t// b\n", []TokenType{TT_COMMENT, TT_COMMENT}, t)
+	checkTypes("func // trailing\nfunc", []TokenType{TT_FUNC, TT_FUNC}, t)
+	checkTypes("foo // trailing\nfunc", []TokenType{TT_IDENT, TT_FUNC}, t)
+	checkTypes("\"//\"", []TokenType{TT_LITSTRING}, t)
+	mustError("/", t)
+	mustError("/ comment", t)
+}
+
+func TestTokenizerPos(t *testing.T) {
+	tz := NewTokenizerString("foo bar\n12\nbaz")
+	exp := []FilePos{{1, 5, "<memory>"}, {1, 9, "<memory>"}, {2, 4, "<memory>"}, {3, 4, "<memory>"}}
+
+	for _, pos := range exp {
+		tk, err := tz.Next()
+
+		if err != nil {
+			t.Fatalf("Unexpected error: %q", err.Error())
+		}
+
+		if *tk.Pos != pos {
+			t.Fatalf("Expected %s but got %s for %q.", &pos, tk.Pos, tk.SVal)
+		}
+	}
 }
 
 func TestTokenizerLits(t *testing.T) {
@@ -32,6 +70,26 @@ func TestTokenizerLits(t *testing.T) {
 	mustError("5..1", t)
 }
 
+func TestTokenizerLitString(t *testing.T) {
+	checkTypes(`"foo"`, []TokenType{TT_LITSTRING}, t)
+	checkTypes(`"" "bar baz"`, []TokenType{TT_LITSTRING, TT_LITSTRING}, t)
+	checkTypes(`"a\"b\\c\n"func`, []TokenType{TT_LITSTRING, TT_FUNC}, t)
+	mustError(`"foo`, t)
+	mustError(`"\x"`, t)
+
+	tk, err := NewTokenizerString(`"a\"b\\c\n\t"`).Next()
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %q", err.Error())
+		return
+	}
+
+	if tk.SVal != "a\"b\\c\n\t" {
+		t.Fatalf("Unexpected value of string literal: %q", tk.SVal)
+		return
+	}
+}
+
 func mustError(str string, t *testing.T) {
 	tz := NewTokenizerString(str)
 
@@ -2,6 +2,8 @@ package gocat
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 )
 
 type TypeError struct {
@@ -53,6 +55,310 @@ var builtins map[string]Type = map[string]Type{
 			},
 		},
 	},
+	"and": &FuncType{
+		ArgTypes: []Type{
+			&PrimType{
+				Type: "bool",
+			},
+			&PrimType{
+				Type: "bool",
+			},
+		},
+		RetTypes: []Type{
+			&PrimType{
+				Type: "bool",
+			},
+		},
+	},
+	"or": &FuncType{
+		ArgTypes: []Type{
+			&PrimType{
+				Type: "bool",
+			},
+			&PrimType{
+				Type: "bool",
+			},
+		},
+		RetTypes: []Type{
+			&PrimType{
+				Type: "bool",
+			},
+		},
+	},
+	"not": &FuncType{
+		ArgTypes: []Type{
+			&PrimType{
+				Type: "bool",
+			},
+		},
+		RetTypes: []Type{
+			&PrimType{
+				Type: "bool",
+			},
+		},
+	},
+	"div.i": &FuncType{
+		ArgTypes: []Type{
+			&PrimType{
+				Type: "int",
+			},
+			&PrimType{
+				Type: "int",
+			},
+		},
+		RetTypes: []Type{
+			&PrimType{
+				Type: "int",
+			},
+		},
+	},
+	"fail": &FuncType{
+		ArgTypes: []Type{
+			&PrimType{
+				Type: "string",
+			},
+		},
+		RetTypes: []Type{},
+	},
+	"error.msg": &FuncType{
+		ArgTypes: []Type{
+			&PrimType{
+				Type: "error",
+			},
+		},
+		RetTypes: []Type{
+			&PrimType{
+				Type: "string",
+			},
+		},
+	},
+	"error.pos": &FuncType{
+		ArgTypes: []Type{
+			&PrimType{
+				Type: "error",
+			},
+		},
+		RetTypes: []Type{
+			&PrimType{
+				Type: "string",
+			},
+		},
+	},
+	"assert.true": &FuncType{
+		ArgTypes: []Type{
+			&PrimType{
+				Type: "bool",
+			},
+		},
+		RetTypes: []Type{},
+	},
+}
+
+// A GenericTyper computes the type of a call to a generic builtin based
+// on the types on the stack at the point of the call.
+type GenericTyper func(stack []Type) (*FuncType, error)
+
+// genericBuiltins are builtins whose type depends on the types of their
+// arguments such as the builtins working on lists and maps.
+var genericBuiltins map[string]GenericTyper = map[string]GenericTyper{
+	"len":    typeLen,
+	"get":    typeGet,
+	"put":    typePut,
+	"append": typeAppend,
+	"each":   typeEach,
+	"try":    typeTry,
+
+	"assert.eq": typeAssertEq,
+}
+
+// peekType returns the n-th type from the top of the stack where n = 1
+// is the type on top of the stack.
+func peekType(stack []Type, n int) (Type, error) {
+	if len(stack) < n {
+		return InvalidType, fmt.Errorf("Not enough arguments.")
+	}
+
+	return stack[len(stack)-n], nil
+}
+
+func typeLen(stack []Type) (*FuncType, error) {
+	typ, err := peekType(stack, 1)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch typ.(type) {
+	case *ListType, *MapType:
+		return &FuncType{
+			ArgTypes: []Type{typ},
+			RetTypes: []Type{&PrimType{Type: "int"}},
+		}, nil
+	}
+
+	return nil, fmt.Errorf("Expected a list or a map but got `%s`.", typ)
+}
+
+func typeGet(stack []Type) (*FuncType, error) {
+	typ, err := peekType(stack, 2)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch typ.(type) {
+	case *ListType:
+		lt := typ.(*ListType)
+		return &FuncType{
+			ArgTypes: []Type{lt, &PrimType{Type: "int"}},
+			RetTypes: []Type{lt.ElemType},
+		}, nil
+	case *MapType:
+		mt := typ.(*MapType)
+		return &FuncType{
+			ArgTypes: []Type{mt, mt.KeyType},
+			RetTypes: []Type{mt.ValueType},
+		}, nil
+	}
+
+	return nil, fmt.Errorf("Expected a list or a map but got `%s`.", typ)
+}
+
+func typePut(stack []Type) (*FuncType, error) {
+	typ, err := peekType(stack, 3)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch typ.(type) {
+	case *ListType:
+		lt := typ.(*ListType)
+		return &FuncType{
+			ArgTypes: []Type{lt, &PrimType{Type: "int"}, lt.ElemType},
+			RetTypes: []Type{lt},
+		}, nil
+	case *MapType:
+		mt := typ.(*MapType)
+		return &FuncType{
+			ArgTypes: []Type{mt, mt.KeyType, mt.ValueType},
+			RetTypes: []Type{mt},
+		}, nil
+	}
+
+	return nil, fmt.Errorf("Expected a list or a map but got `%s`.", typ)
+}
+
+func typeAppend(stack []Type) (*FuncType, error) {
+	typ, err := peekType(stack, 2)
+
+	if err != nil {
+		return nil, err
+	}
+
+	lt, ok := typ.(*ListType)
+
+	if !ok {
+		return nil, fmt.Errorf("Expected a list but got `%s`.", typ)
+	}
+
+	return &FuncType{
+		ArgTypes: []Type{lt, lt.ElemType},
+		RetTypes: []Type{lt},
+	}, nil
+}
+
+func typeEach(stack []Type) (*FuncType, error) {
+	typ, err := peekType(stack, 2)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// The quotation is called with each element (or each key and value)
+	// and must not return anything.
+	switch typ.(type) {
+	case *ListType:
+		lt := typ.(*ListType)
+		return &FuncType{
+			ArgTypes: []Type{
+				lt,
+				&FuncType{
+					ArgTypes: []Type{lt.ElemType},
+					RetTypes: []Type{},
+				},
+			},
+			RetTypes: []Type{},
+		}, nil
+	case *MapType:
+		mt := typ.(*MapType)
+		return &FuncType{
+			ArgTypes: []Type{
+				mt,
+				&FuncType{
+					ArgTypes: []Type{mt.KeyType, mt.ValueType},
+					RetTypes: []Type{},
+				},
+			},
+			RetTypes: []Type{},
+		}, nil
+	}
+
+	return nil, fmt.Errorf("Expected a list or a map but got `%s`.", typ)
+}
+
+// typeAssertEq types `assert.eq` which compares two values of the same
+// type.
+func typeAssertEq(stack []Type) (*FuncType, error) {
+	a, err := peekType(stack, 2)
+
+	if err != nil {
+		return nil, err
+	}
+
+	b, _ := peekType(stack, 1)
+
+	if !TypeEqual(a, b) {
+		return nil, fmt.Errorf("Expected two values of the same type but got `%s` and `%s`.", a, b)
+	}
+
+	return &FuncType{
+		ArgTypes: []Type{a, b},
+		RetTypes: []Type{},
+	}, nil
+}
+
+// typeTry types `try` which calls the quotation below the handler on top
+// of the stack. If the quotation fails the handler is called with the
+// arguments of the quotation and the error instead. Thus the handler
+// must take the same arguments plus an error and must return the same
+// types as the quotation.
+func typeTry(stack []Type) (*FuncType, error) {
+	typ, err := peekType(stack, 2)
+
+	if err != nil {
+		return nil, err
+	}
+
+	quot, ok := typ.(*FuncType)
+
+	if !ok {
+		return nil, fmt.Errorf("Expected a function but got `%s`.", typ)
+	}
+
+	handlerArgTypes := append(make([]Type, 0, len(quot.ArgTypes)+1), quot.ArgTypes...)
+
+	handler := &FuncType{
+		ArgTypes: append(handlerArgTypes, &PrimType{Type: "error"}),
+		RetTypes: quot.RetTypes,
+	}
+
+	argTypes := append(make([]Type, 0, len(quot.ArgTypes)+2), quot.ArgTypes...)
+
+	return &FuncType{
+		ArgTypes: append(argTypes, quot, handler),
+		RetTypes: quot.RetTypes,
+	}, nil
 }
 
 func TypeCompatibleWith(a Type, b Type) bool {
@@ -64,10 +370,8 @@ func TypeCompatibleWith(a Type, b Type) bool {
 		default:
 			return false
 		}
-	case *PrimType:
+	case *PrimType, *RecordType, *ListType, *MapType, *FuncType, *ContractType:
 		switch b.(type) {
-		case *PrimType:
-			return TypeEqual(a, b)
 		case *UnionType:
 			ut := b.(*UnionType)
 
@@ -79,7 +383,7 @@ func TypeCompatibleWith(a Type, b Type) bool {
 
 			return false
 		default:
-			return false
+			return TypeEqual(a, b)
 		}
 	case *UnionType:
 		switch b.(type) {
@@ -118,73 +422,666 @@ func InferTypes(node Node, stack []Type, typeWorlds TypeWorlds) ([]Type, error)
 		return append(stack, &PrimType{Type: "float"}), nil
 	case *LitIntNode:
 		return append(stack, &PrimType{Type: "int"}), nil
+	case *LitBoolNode:
+		return append(stack, &PrimType{Type: "bool"}), nil
+	case *LitStringNode:
+		return append(stack, &PrimType{Type: "string"}), nil
 
-	case *ExpNode:
-		exp := node.(*ExpNode)
+	case *IfElseNode:
+		ifn := node.(*IfElseNode)
 
-		for _, v := range exp.Exps {
-			switch v.(type) {
-			// If the expression contains a literal just push the type
-			// of the literal to the stack.
-			case *LitIntNode:
-				stack = append(stack, &PrimType{Type: "int"})
-			case *LitFloatNode:
-				stack = append(stack, &PrimType{Type: "float"})
-
-			// If it's a verb we need to look up what argument types it expects
-			// and what return types it has.
-			case *VerbNode:
-				verb := v.(*VerbNode).Verb
-				ft := typeWorlds.Lookup(verb)
-
-				if ft == nil {
-					return nil, fmt.Errorf("Function `%s` does not exist!", verb)
-				}
-				funcType, ok := ft.(*FuncType)
+		var err error
+		stack, err = InferTypes(ifn.Condition, stack, typeWorlds)
 
-				if !ok {
-					return nil, fmt.Errorf("`%s` is not of type function.", verb)
-				}
+		if err != nil {
+			return nil, err
+		}
 
-				if len(stack) < len(funcType.ArgTypes) {
-					return nil, fmt.Errorf("Not enough arguments.")
-				}
+		if err := checkReachable(stack); err != nil {
+			return nil, err
+		}
+
+		if len(stack) == 0 {
+			return nil, fmt.Errorf("Condition of `if` %s does not leave a value on the stack.", ifn.Token.Pos)
+		}
+
+		cond := stack[len(stack)-1]
+		boolType := &PrimType{Type: "bool"}
+
+		if !TypeEqual(cond, boolType) {
+			return nil, &TypeError{
+				Wanted: boolType,
+				Got:    cond,
+				Token:  ifn.Token,
+				Extra:  "in condition of `if`.",
+			}
+		}
+
+		// Pop the condition from the stack
+		stack = stack[:len(stack)-1]
+
+		thenStack, err := inferBlock(ifn.ThenBlock, stack, typeWorlds)
+
+		if err != nil {
+			return nil, err
+		}
+
+		elseStack, err := inferBlock(ifn.ElseBlock, stack, typeWorlds)
+
+		if err != nil {
+			return nil, err
+		}
+
+		// A branch which fails fits whatever the other branch leaves.
+		if diverges(thenStack) {
+			return elseStack, nil
+		}
+
+		if diverges(elseStack) {
+			return thenStack, nil
+		}
+
+		// Both branches must leave the same types on the stack otherwise
+		// we can't know what's on the stack after the if.
+		if !TypesEqual(thenStack, elseStack) {
+			return nil, fmt.Errorf("Branches of `if` %s leave different types on the stack. Then block leaves %s but else block leaves %s.",
+				ifn.Token.Pos, thenStack, elseStack)
+		}
+
+		return thenStack, nil
+
+	case *MatchNode:
+		mn := node.(*MatchNode)
+
+		if len(stack) == 0 {
+			return nil, fmt.Errorf("Nothing to match on at %s. The stack is empty.", mn.Token.Pos)
+		}
+
+		top := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		// The members of the type we're matching on. Each member must be
+		// handled by exactly one case.
+		members := []Type{top}
+
+		if ut, ok := top.(*UnionType); ok {
+			members = ut.Types
+		}
+
+		covered := make([]bool, len(members))
+
+		// resStack is what the first case which doesn't fail leaves on
+		// the stack. If all cases fail the match fails too.
+		var resStack []Type = nil
+		var resCase *MatchCase = nil
 
-				m := len(funcType.ArgTypes)
-
-				// On top of the stack is the last argument type so the first argument
-				// type according to funcType.ArgTypes is offset by minus the amount of
-				// arguments the function expects.
-				for i := 0; i < m; i++ {
-					got := stack[len(stack)-m+i]
-					wanted := funcType.ArgTypes[i]
-					if !TypeCompatibleWith(got, wanted) {
-						return nil, &TypeError{
-							Wanted: wanted,
-							Got:    got,
-							Token:  exp.Token,
-							Extra:  fmt.Sprintf("in a call to `%s`.", verb),
+		for i, mc := range mn.Cases {
+			caseMembers := []Type{mc.Type}
+
+			if ut, ok := mc.Type.(*UnionType); ok {
+				caseMembers = ut.Types
+			}
+
+			for _, caseMember := range caseMembers {
+				found := false
+
+				for j, member := range members {
+					if TypeEqual(caseMember, member) {
+						if covered[j] {
+							return nil, fmt.Errorf("Duplicate case for type `%s` in match %s.", member, mc.Token.Pos)
 						}
+
+						covered[j] = true
+						found = true
+						break
 					}
 				}
 
-				// Pop the argument types from the stack
-				stack = stack[:len(stack)-m]
+				if !found {
+					return nil, &TypeError{
+						Wanted: top,
+						Got:    mc.Type,
+						Token:  mc.Token,
+						Extra:  "in case of `match`.",
+					}
+				}
+			}
+
+			// Within the case the value on top of the stack is narrowed
+			// to the type of the case.
+			caseStack := append(make([]Type, 0, len(stack)+1), stack...)
+			caseStack, err := inferBlock(mc.Body, append(caseStack, mc.Type), typeWorlds)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if i == 0 || (diverges(resStack) && !diverges(caseStack)) {
+				resStack = caseStack
+				resCase = mc
+			} else if !diverges(caseStack) && !TypesEqual(resStack, caseStack) {
+				return nil, fmt.Errorf("Cases of `match` %s leave different types on the stack. Case `%s` leaves %s but case `%s` leaves %s.",
+					mn.Token.Pos, resCase.Type, resStack, mc.Type, caseStack)
+			}
+		}
+
+		for j, member := range members {
+			if !covered[j] {
+				return nil, fmt.Errorf("Missing case for type `%s` in match %s.", member, mn.Token.Pos)
+			}
+		}
+
+		return resStack, nil
+
+	case *LitListNode, *LitMapNode:
+		return inferData(node, stack, typeWorlds, nil)
+
+	case *ExpNode:
+		exp := node.(*ExpNode)
+
+		var err error
+
+		for _, v := range exp.Exps {
+			if err := checkReachable(stack); err != nil {
+				return nil, err
+			}
+
+			stack, err = inferData(v, stack, typeWorlds, exp.Token)
+
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return stack, nil
+	}
+
+	return nil, fmt.Errorf("Can't infer types.")
+}
+
+// inferData infers the types of an element of an expression. Type
+// errors are reported at the position of tk if given.
+func inferData(v Node, stack []Type, typeWorlds TypeWorlds, tk *Token) ([]Type, error) {
+	switch v.(type) {
+	// If the expression contains a literal just push the type
+	// of the literal to the stack.
+	case *LitIntNode:
+		return append(stack, &PrimType{Type: "int"}), nil
+	case *LitFloatNode:
+		return append(stack, &PrimType{Type: "float"}), nil
+	case *LitBoolNode:
+		return append(stack, &PrimType{Type: "bool"}), nil
+	case *LitStringNode:
+		return append(stack, &PrimType{Type: "string"}), nil
+
+	// Each element of a collection literal must produce exactly one
+	// value that fits into the collection.
+	case *LitListNode:
+		lit := v.(*LitListNode)
+
+		for _, elem := range lit.Elems {
+			err := inferElem(elem, lit.Type.ElemType, typeWorlds, lit.Token)
+
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return append(stack, lit.Type), nil
+	case *LitMapNode:
+		lit := v.(*LitMapNode)
+
+		for i := 0; i < len(lit.Keys); i++ {
+			err := inferElem(lit.Keys[i], lit.Type.KeyType, typeWorlds, lit.Token)
+
+			if err != nil {
+				return nil, err
+			}
+
+			err = inferElem(lit.Values[i], lit.Type.ValueType, typeWorlds, lit.Token)
+
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return append(stack, lit.Type), nil
 
-				// And push the return types
-				for _, rettyp := range funcType.RetTypes {
-					stack = append(stack, rettyp)
+	// A quotation pushes the function it quotes.
+	case *QuotNode:
+		quot := v.(*QuotNode)
+		ft := typeWorlds.Lookup(quot.Ident)
+
+		if ft == nil {
+			if genericBuiltins[quot.Ident] != nil {
+				return nil, fmt.Errorf("Generic function `%s` can not be quoted.", quot.Ident)
+			}
+
+			return nil, fmt.Errorf("Function `%s` does not exist!", quot.Ident)
+		}
+
+		funcType, ok := ft.(*FuncType)
+
+		if !ok {
+			return nil, fmt.Errorf("`%s` is not of type function.", quot.Ident)
+		}
+
+		return append(stack, funcType), nil
+
+	// If it's a verb we need to look up what argument types it expects
+	// and what return types it has.
+	case *VerbNode:
+		verb := v.(*VerbNode).Verb
+
+		if tk == nil {
+			tk = v.(*VerbNode).Token
+		}
+
+		ft := typeWorlds.Lookup(verb)
+
+		if ft == nil {
+			// Generic builtins compute their type based on what
+			// is on the stack.
+			typer := genericBuiltins[verb]
+
+			if typer == nil {
+				return nil, fmt.Errorf("Function `%s` does not exist!", verb)
+			}
+
+			var err error
+			ft, err = typer(stack)
+
+			if err != nil {
+				return nil, fmt.Errorf("Can't call `%s` %s: %s", verb, tk.Pos, err.Error())
+			}
+		}
+
+		funcType, ok := ft.(*FuncType)
+
+		if !ok {
+			return nil, fmt.Errorf("`%s` is not of type function.", verb)
+		}
+
+		if len(stack) < len(funcType.ArgTypes) {
+			return nil, fmt.Errorf("Not enough arguments.")
+		}
+
+		m := len(funcType.ArgTypes)
+
+		// On top of the stack is the last argument type so the first argument
+		// type according to funcType.ArgTypes is offset by minus the amount of
+		// arguments the function expects.
+		for i := 0; i < m; i++ {
+			got := stack[len(stack)-m+i]
+			wanted := funcType.ArgTypes[i]
+			if !TypeCompatibleWith(got, wanted) {
+				return nil, &TypeError{
+					Wanted: wanted,
+					Got:    got,
+					Token:  tk,
+					Extra:  fmt.Sprintf("in a call to `%s`.", verb),
 				}
 			}
 		}
 
+		// `fail` never returns so whatever the stack holds after it
+		// does not matter.
+		if funcType == builtins["fail"] {
+			return []Type{&neverType{Token: tk}}, nil
+		}
+
+		// Pop the argument types from the stack
+		stack = stack[:len(stack)-m]
+
+		// And push the return types
+		for _, rettyp := range funcType.RetTypes {
+			stack = append(stack, rettyp)
+		}
+
 		return stack, nil
 	}
 
 	return nil, fmt.Errorf("Can't infer types.")
 }
 
+// inferElem checks that an element of a collection literal produces
+// exactly one value compatible with typ.
+func inferElem(elem Node, typ Type, typeWorlds TypeWorlds, tk *Token) error {
+	types, err := inferData(elem, nil, typeWorlds, tk)
+
+	if err != nil {
+		return err
+	}
+
+	if len(types) != 1 {
+		return fmt.Errorf("Element of collection literal %s must produce exactly one value but produced %d.",
+			tk.Pos, len(types))
+	}
+
+	if !TypeCompatibleWith(types[0], typ) {
+		return &TypeError{
+			Wanted: typ,
+			Got:    types[0],
+			Token:  tk,
+			Extra:  "in element of collection literal.",
+		}
+	}
+
+	return nil
+}
+
+// argsTypeWorld returns the type world of the arguments of a function.
+// Within the body of the function arguments are verbs without arguments
+// pushing the value of the argument.
+func argsTypeWorld(fn *Func) TypeWorld {
+	argsWorld := make(TypeWorld)
+
+	for _, arg := range fn.FuncNode.Args {
+		argsWorld[arg.Name] = &FuncType{
+			ArgTypes: []Type{},
+			RetTypes: []Type{arg.Type},
+		}
+	}
+
+	return argsWorld
+}
+
+// neverType is what the stack holds after a call to `fail`. It is not
+// the type of any value and is compatible with every stack.
+type neverType struct {
+	// Token is the token of the call to `fail`.
+	Token *Token
+}
+
+func (*neverType) IsType() bool {
+	return true
+}
+
+func (*neverType) String() string {
+	return "never"
+}
+
+// diverges returns true if the stack is that of code which failed.
+func diverges(stack []Type) bool {
+	if len(stack) != 1 {
+		return false
+	}
+
+	_, ok := stack[0].(*neverType)
+	return ok
+}
+
+// checkReachable returns an error if code following the stack can't
+// be reached because the code before it failed.
+func checkReachable(stack []Type) error {
+	if !diverges(stack) {
+		return nil
+	}
+
+	return fmt.Errorf("Unreachable code after `fail` %s.", stack[0].(*neverType).Token.Pos)
+}
+
+// inferBlock infers the types of a block of nodes. The block works
+// on a copy of the stack so that it can't clobber the stack of other
+// blocks (e.g. the other branch of an if).
+func inferBlock(nodes []Node, stack []Type, typeWorlds TypeWorlds) ([]Type, error) {
+	stack = append(make([]Type, 0, len(stack)), stack...)
+
+	var err error
+
+	for _, node := range nodes {
+		if err := checkReachable(stack); err != nil {
+			return nil, err
+		}
+
+		stack, err = InferTypes(node, stack, typeWorlds)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return stack, nil
+}
+
 func TypeCheck(modules map[string]*Module) error {
+	return TypeCheckWith(modules, nil, nil)
+}
+
+// TypeCheckWith type checks the modules with the host functions of host
+// that are visible with the capabilities caps. Warnings are stored in
+// the modules.
+func TypeCheckWith(modules map[string]*Module, host *Host, caps Capabilities) error {
+	err := checkRequires(modules)
+
+	if err != nil {
+		return err
+	}
+
+	err = checkImports(modules)
+
+	if err != nil {
+		return err
+	}
+
+	err = resolveModules(modules)
+
+	if err != nil {
+		return err
+	}
+
+	return typeCheckModules(modules, host, caps, nil)
+}
+
+// resolveModules resolves references to records of other modules.
+func resolveModules(modules map[string]*Module) error {
+	// Resolve references to records of other modules. References to
+	// records of the same module have been resolved by LoadModule.
+	missing := ""
+	lookup := func(name string) *RecordType {
+		i := strings.LastIndex(name, ":")
+
+		if i < 0 {
+			return nil
+		}
+
+		module := modules[name[:i]]
+
+		if module != nil && module.Types[name[i+1:]] != nil {
+			return module.Types[name[i+1:]]
+		}
+
+		missing = name
+		return nil
+	}
+
+	for _, name := range sortedNames(modules) {
+		module := modules[name]
+		resolveModule(module, lookup)
+
+		if missing != "" {
+			return fmt.Errorf("Type `%s` referenced in module `%s` does not exist.", missing, module.Name)
+		}
+	}
+
+	return checkMapKeys(modules)
+}
+
+// isKeyType returns true if values of the type typ may be keys of maps.
+// Keys are compared and ordered by value at runtime which rules out
+// records and errors.
+func isKeyType(typ Type) bool {
+	pt, ok := typ.(*PrimType)
+
+	if !ok {
+		return false
+	}
+
+	switch pt.Type {
+	case "int", "float", "bool", "string":
+		return true
+	}
+
+	return false
+}
+
+// checkKeyTypes checks the key types of the map types in typ. Fields of
+// records are checked with the records.
+func checkKeyTypes(typ Type) error {
+	switch typ := typ.(type) {
+	case *MapType:
+		if !isKeyType(typ.KeyType) {
+			return fmt.Errorf("Keys of maps must be of type `int`, `float`, `bool` or `string` but got `%s`.", typ.KeyType)
+		}
+
+		return checkKeyTypes(typ.ValueType)
+	case *ListType:
+		return checkKeyTypes(typ.ElemType)
+	case *FuncType:
+		for _, t := range typ.ArgTypes {
+			if err := checkKeyTypes(t); err != nil {
+				return err
+			}
+		}
+
+		for _, t := range typ.RetTypes {
+			if err := checkKeyTypes(t); err != nil {
+				return err
+			}
+		}
+	case *ContractType:
+		for _, name := range sortedNames(typ.Funcs) {
+			if err := checkKeyTypes(typ.Funcs[name]); err != nil {
+				return err
+			}
+		}
+	case *UnionType:
+		for _, t := range typ.Types {
+			if err := checkKeyTypes(t); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkNodeKeyTypes checks the key types of the map types mentioned in
+// nodes.
+func checkNodeKeyTypes(nodes []Node) error {
+	for _, node := range nodes {
+		var err error
+
+		switch node := node.(type) {
+		case *ExpNode:
+			err = checkNodeKeyTypes(node.Exps)
+		case *LitListNode:
+			err = checkKeyTypes(node.Type)
+
+			if err == nil {
+				err = checkNodeKeyTypes(node.Elems)
+			}
+
+			if err != nil {
+				return fmt.Errorf("%s %s", node.Token.Pos, err.Error())
+			}
+		case *LitMapNode:
+			err = checkKeyTypes(node.Type)
+
+			if err == nil {
+				err = checkNodeKeyTypes(node.Keys)
+			}
+
+			if err == nil {
+				err = checkNodeKeyTypes(node.Values)
+			}
+
+			if err != nil {
+				return fmt.Errorf("%s %s", node.Token.Pos, err.Error())
+			}
+		case *IfElseNode:
+			err = checkNodeKeyTypes([]Node{node.Condition})
+
+			if err == nil {
+				err = checkNodeKeyTypes(node.ThenBlock)
+			}
+
+			if err == nil {
+				err = checkNodeKeyTypes(node.ElseBlock)
+			}
+		case *MatchNode:
+			for _, mc := range node.Cases {
+				err = checkKeyTypes(mc.Type)
+
+				if err != nil {
+					return fmt.Errorf("%s %s", mc.Token.Pos, err.Error())
+				}
+
+				err = checkNodeKeyTypes(mc.Body)
+
+				if err != nil {
+					break
+				}
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkMapKeys checks that the keys of the map types mentioned in the
+// modules are of a type that can be compared by value. This can only be
+// checked once references to records have been resolved because the
+// parser can't tell records apart from primitive types.
+func checkMapKeys(modules map[string]*Module) error {
+	for _, name := range sortedNames(modules) {
+		module := modules[name]
+
+		for _, tname := range sortedNames(module.Types) {
+			for _, field := range module.Types[tname].Fields {
+				if err := checkKeyTypes(field.Type); err != nil {
+					return fmt.Errorf("Field `%s` of record `%s:%s`: %s", field.Name, name, tname, err.Error())
+				}
+			}
+		}
+
+		for _, fname := range sortedNames(module.Funcs) {
+			fn := module.Funcs[fname]
+			err := checkKeyTypes(fn.Type)
+
+			if err == nil && fn.FuncNode != nil {
+				err = checkNodeKeyTypes(fn.FuncNode.Body)
+			}
+
+			if err != nil {
+				return fmt.Errorf("Function `%s:%s`: %s", name, fname, err.Error())
+			}
+		}
+	}
+
+	return nil
+}
+
+// typeCheckModules type checks the functions of the modules. Functions
+// for which skip returns true are assumed to be correct and are not
+// checked. The types of the modules must have been resolved.
+func typeCheckModules(modules map[string]*Module, host *Host, caps Capabilities, skip func(fqname string) bool) error {
+	err := checkInits(modules)
+
+	if err != nil {
+		return err
+	}
+
+	err = checkTests(modules)
+
+	if err != nil {
+		return err
+	}
+
 	modulesTypeWorld := make(TypeWorld)
 
 	// Loop through all the modules to compute the
@@ -202,45 +1099,197 @@ func TypeCheck(modules map[string]*Module) error {
 	}
 
 	// The typeWorlds consists of the typeWorld of all the
-	// builtins and the modulesTypeWorld where the
-	// modulesTypeWorld can override builtins.
-	typeWorlds := NewTypeWorlds(builtins, modulesTypeWorld)
+	// builtins, the visible host functions and the modulesTypeWorld
+	// where the modulesTypeWorld can override builtins. The functions
+	// of each module see the functions of their own module by their
+	// unqualified names.
+	hostTypeWorld := host.TypeWorld(caps)
+	moduleTypeWorlds := make(map[string]TypeWorlds)
 
-	for k, v := range modules {
-		if k != v.Name {
-			panic("BUG: names don't match?")
+	for name, module := range modules {
+		moduleTypeWorlds[name] = NewTypeWorlds(builtins, hostTypeWorld, modulesTypeWorld, localTypeWorld(module))
+	}
+
+	// Functions are type checked concurrently. They are sorted by their
+	// fully qualified names so that the reported error does not depend
+	// on the order of maps or on scheduling.
+	var fns []*Func
+	var owners []*Module
+
+	for _, name := range sortedNames(modules) {
+		module := modules[name]
+
+		for _, fname := range sortedNames(module.Funcs) {
+			fn := module.Funcs[fname]
+
+			// Functions without code have nothing to check.
+			if fn.FuncNode != nil && (skip == nil || !skip(name+":"+fname)) {
+				fns = append(fns, fn)
+				owners = append(owners, module)
+			}
 		}
+	}
 
-		for _, fn := range v.Funcs {
+	return forEachParallel(len(fns), func(i int) error {
+		err := checkRefs(owners[i], fns[i], modules, hostTypeWorld)
 
-			types := make([]Type, 0)
-			var err error
+		if err != nil {
+			return err
+		}
 
-			for _, node := range fn.FuncNode.Body {
-				types, err = InferTypes(node, types, typeWorlds)
+		return typeCheckFunc(fns[i], moduleTypeWorlds[owners[i].Name])
+	})
+}
 
-				if err != nil {
-					return err
+// localTypeWorld returns the types of the functions of the module by
+// their unqualified names.
+func localTypeWorld(module *Module) TypeWorld {
+	tw := make(TypeWorld)
+
+	for name, fn := range module.Funcs {
+		tw[name] = fn.Type
+	}
+
+	return tw
+}
+
+// checkRefs checks that the function fn of the module owner only
+// references public functions of other modules and that its unqualified
+// references to functions of owner are not ambiguous.
+func checkRefs(owner *Module, fn *Func, modules map[string]*Module, hostTypeWorld TypeWorld) error {
+	return walkRefs(fn.FuncNode.Body, func(name string, tk *Token) error {
+		i := strings.LastIndex(name, ":")
+
+		if i < 0 {
+			// Arguments shadow functions.
+			for _, arg := range fn.FuncNode.Args {
+				if arg.Name == name {
+					return nil
 				}
 			}
 
-			if len(types) != len(fn.Type.RetTypes) {
-				return fmt.Errorf("Function `%s` does not return the right amount of values. Wanted %d but got %d.",
-					fn.Name, len(fn.Type.RetTypes), len(types))
+			if owner.Funcs[name] != nil &&
+				(builtins[name] != nil || genericBuiltins[name] != nil || hostTypeWorld[name] != nil) {
+				return fmt.Errorf("Reference to `%s` %s is ambiguous. It may be the builtin or host function `%s` or `%s:%s`.",
+					name, tk.Pos, name, owner.Name, name)
 			}
 
-			for i := 0; i < len(types); i++ {
-				if !TypeCompatibleWith(types[i], fn.Type.RetTypes[i]) {
-					return &TypeError{
-						Wanted: fn.Type.RetTypes[i],
-						Got:    types[i],
-						Token:  fn.FuncNode.Token,
-						Extra:  fmt.Sprintf("(in returned values of function `%s`)", fn.Name),
-					}
+			return nil
+		}
+
+		if name[:i] == owner.Name {
+			return nil
+		}
+
+		module := modules[name[:i]]
+
+		if module == nil || module.Funcs[name[i+1:]] == nil {
+			// The type checker reports functions that don't exist.
+			return nil
+		}
+
+		if !module.Funcs[name[i+1:]].Pub {
+			return fmt.Errorf("Function `%s` referenced %s is private to module `%s`.", name, tk.Pos, module.Name)
+		}
+
+		return nil
+	})
+}
+
+// walkRefs calls visit for every function called or quoted in nodes.
+func walkRefs(nodes []Node, visit func(name string, tk *Token) error) error {
+	for _, node := range nodes {
+		var err error
+
+		switch node.(type) {
+		case *VerbNode:
+			err = visit(node.(*VerbNode).Verb, node.(*VerbNode).Token)
+		case *QuotNode:
+			err = visit(node.(*QuotNode).Ident, node.(*QuotNode).Token)
+		case *ExpNode:
+			err = walkRefs(node.(*ExpNode).Exps, visit)
+		case *LitListNode:
+			err = walkRefs(node.(*LitListNode).Elems, visit)
+		case *LitMapNode:
+			err = walkRefs(node.(*LitMapNode).Keys, visit)
+
+			if err == nil {
+				err = walkRefs(node.(*LitMapNode).Values, visit)
+			}
+		case *IfElseNode:
+			ifn := node.(*IfElseNode)
+			err = walkRefs([]Node{ifn.Condition}, visit)
+
+			if err == nil {
+				err = walkRefs(ifn.ThenBlock, visit)
+			}
+
+			if err == nil {
+				err = walkRefs(ifn.ElseBlock, visit)
+			}
+		case *MatchNode:
+			for _, mc := range node.(*MatchNode).Cases {
+				if err == nil {
+					err = walkRefs(mc.Body, visit)
 				}
 			}
 		}
+
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
+
+// typeCheckFunc type checks the body of the function fn against its
+// declared return types.
+func typeCheckFunc(fn *Func, typeWorlds TypeWorlds) error {
+	// typeWorlds is shared between goroutines and must not be appended
+	// to in place.
+	tws := make(TypeWorlds, 0, len(typeWorlds)+1)
+	tws = append(tws, typeWorlds...)
+	tws = append(tws, argsTypeWorld(fn))
+
+	types, err := inferBlock(fn.FuncNode.Body, nil, tws)
+
+	if err != nil {
+		return err
+	}
+
+	// A function ending in `fail` returns whatever it claims to.
+	if diverges(types) {
+		return nil
+	}
+
+	if len(types) != len(fn.Type.RetTypes) {
+		return fmt.Errorf("Function `%s` does not return the right amount of values. Wanted %d but got %d.",
+			fn.Name, len(fn.Type.RetTypes), len(types))
+	}
+
+	for i := 0; i < len(types); i++ {
+		if !TypeCompatibleWith(types[i], fn.Type.RetTypes[i]) {
+			return &TypeError{
+				Wanted: fn.Type.RetTypes[i],
+				Got:    types[i],
+				Token:  fn.FuncNode.Token,
+				Extra:  fmt.Sprintf("(in returned values of function `%s`)", fn.Name),
+			}
+		}
+	}
+
+	return nil
+}
+
+// sortedNames returns the sorted keys of m.
+func sortedNames[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
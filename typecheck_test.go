@@ -1,6 +1,8 @@
 package gocat
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -11,6 +13,308 @@ func TestInferType(t *testing.T) {
 		&PrimType{Type: "float"}, t)
 }
 
+func TestInferTypeQuot(t *testing.T) {
+	squareType := builtins["square.i"]
+	checkInferedTypeExp("'square.i;", []Type{squareType}, t)
+	checkInferedTypeExp("5 'not;", []Type{&PrimType{Type: "int"}, builtins["not"]}, t)
+
+	p := NewParser(NewTokenizerString("'doesnotexist;"))
+	n, err := p.parseExp()
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+		return
+	}
+
+	_, err = InferTypes(n, nil, NewTypeWorlds(builtins))
+
+	if err == nil {
+		t.Fatalf("Expected error but got none.")
+		return
+	}
+}
+
+func TestTypeCompatibleWithFunc(t *testing.T) {
+	squareType := builtins["square.i"]
+	notType := builtins["not"]
+	ut, _ := NewUnionType([]Type{&PrimType{Type: "int"}, squareType})
+
+	if !TypeCompatibleWith(squareType, ut) {
+		t.Fatalf("Expected %s to be compatible with %s.", squareType, ut)
+	}
+
+	if TypeCompatibleWith(notType, ut) {
+		t.Fatalf("Expected %s to not be compatible with %s.", notType, ut)
+	}
+
+	if !TypeCompatibleWith(squareType, squareType) {
+		t.Fatalf("Expected %s to be compatible with itself.", squareType)
+	}
+}
+
+func TestInferTypeCollections(t *testing.T) {
+	intList := &ListType{ElemType: &PrimType{Type: "int"}}
+	intFloatMap := &MapType{KeyType: &PrimType{Type: "int"}, ValueType: &PrimType{Type: "float"}}
+
+	checkInferedTypeExp("list<int>[1 2 3];", []Type{intList}, t)
+	checkInferedTypeExp("list<func{int : int}>['square.i];", []Type{&ListType{ElemType: builtins["square.i"]}}, t)
+	checkInferedTypeExp("map<int float>[1 1.0];", []Type{intFloatMap}, t)
+	checkInferedTypeExp("list<list<int>>[list<int>[1]];", []Type{&ListType{ElemType: intList}}, t)
+	checkInferedTypeExp("list<int>[1 2] len;", []Type{&PrimType{Type: "int"}}, t)
+	checkInferedTypeExp("map<int float>[] len;", []Type{&PrimType{Type: "int"}}, t)
+	checkInferedTypeExp("list<int>[1 2] 0 get;", []Type{&PrimType{Type: "int"}}, t)
+	checkInferedTypeExp("map<int float>[] 0 get;", []Type{&PrimType{Type: "float"}}, t)
+	checkInferedTypeExp("list<int>[] 0 5 put;", []Type{intList}, t)
+	checkInferedTypeExp("map<int float>[] 0 5.0 put;", []Type{intFloatMap}, t)
+	checkInferedTypeExp("list<int>[] 5 append;", []Type{intList}, t)
+
+	mustErrorInferedTypeExp("list<int>[1.0];",
+		&PrimType{Type: "int"},
+		&PrimType{Type: "float"}, t)
+	mustErrorInferedTypeExp("list<int>[] 0.0 get;",
+		&PrimType{Type: "int"},
+		&PrimType{Type: "float"}, t)
+	mustErrorInferedTypeExp("map<int float>[] 0 5 put;",
+		&PrimType{Type: "float"},
+		&PrimType{Type: "int"}, t)
+	mustErrorInferedTypeExp("list<int>[] 5.0 append;",
+		&PrimType{Type: "int"},
+		&PrimType{Type: "float"}, t)
+	mustErrorInferedTypeExp("list<int>[] 'square.i each;",
+		&FuncType{ArgTypes: []Type{&PrimType{Type: "int"}}, RetTypes: []Type{}},
+		builtins["square.i"], t)
+
+	mustErrorInferedTypeExpAny("5 len;", t)
+	mustErrorInferedTypeExpAny("5 5 append;", t)
+	mustErrorInferedTypeExpAny("list<int>[1 2.0 and];", t)
+	mustErrorInferedTypeExpAny("'len;", t)
+}
+
+func mustErrorInferedTypeExpAny(code string, t *testing.T) {
+	p := NewParser(NewTokenizerString(code))
+	n, err := p.parseExp()
+
+	if err != nil {
+		t.Fatalf("Unexpected error for %s: %s", code, err.Error())
+		return
+	}
+
+	typ, err := InferTypes(n, nil, NewTypeWorlds(builtins))
+
+	if err == nil {
+		t.Fatalf("Expected error but got none for: %s. {%s}", code, typ)
+		return
+	}
+}
+
+func TestInferTypeTry(t *testing.T) {
+	tw := TypeWorld{
+		"half": &FuncType{
+			ArgTypes: []Type{&PrimType{Type: "int"}},
+			RetTypes: []Type{&PrimType{Type: "int"}},
+		},
+		"zero": &FuncType{
+			ArgTypes: []Type{&PrimType{Type: "int"}, &PrimType{Type: "error"}},
+			RetTypes: []Type{&PrimType{Type: "int"}},
+		},
+		"zerof": &FuncType{
+			ArgTypes: []Type{&PrimType{Type: "int"}, &PrimType{Type: "error"}},
+			RetTypes: []Type{&PrimType{Type: "float"}},
+		},
+	}
+
+	typeWorlds := NewTypeWorlds(builtins, tw)
+
+	types, err := inferTestExp("5 'half 'zero try;", typeWorlds)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+		return
+	}
+
+	if !TypesEqual(types, []Type{&PrimType{Type: "int"}}) {
+		t.Fatalf("Expected [int] but got %s.", types)
+		return
+	}
+
+	types, err = inferTestExp("\"oops\" fail;", typeWorlds)
+
+	if err != nil || !diverges(types) {
+		t.Fatalf("Unexpected result: %s %v", types, err)
+		return
+	}
+
+	for _, code := range []string{
+		"5 'half 'half try;",
+		"5 'half 'zerof try;",
+		"5.0 'half 'zero try;",
+		"5 5 'zero try;",
+		"'half try;",
+	} {
+		_, err = inferTestExp(code, typeWorlds)
+
+		if err == nil {
+			t.Fatalf("Expected error but got none for: %s", code)
+			return
+		}
+	}
+}
+
+func inferTestExp(code string, typeWorlds TypeWorlds) ([]Type, error) {
+	p := NewParser(NewTokenizerString(code))
+	n, err := p.parseExp()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return InferTypes(n, nil, typeWorlds)
+}
+
+func TestInferTypeBool(t *testing.T) {
+	checkInferedTypeExp("true false;", []Type{&PrimType{Type: "bool"}, &PrimType{Type: "bool"}}, t)
+	checkInferedTypeExp("true false and not;", []Type{&PrimType{Type: "bool"}}, t)
+	checkInferedTypeExp("true false or;", []Type{&PrimType{Type: "bool"}}, t)
+	mustErrorInferedTypeExp("5 not;",
+		&PrimType{Type: "bool"},
+		&PrimType{Type: "int"}, t)
+}
+
+func TestInferTypeIf(t *testing.T) {
+	checkInferedTypeIf("if true { 5; } else { 6 square.i; }", []Type{&PrimType{Type: "int"}}, t)
+	checkInferedTypeIf("if true false and { }", []Type{}, t)
+	mustErrorInferedTypeIf("if 5 { }",
+		&PrimType{Type: "bool"},
+		&PrimType{Type: "int"}, t)
+
+	// Branches leaving different types on the stack.
+	p := NewParser(NewTokenizerString("if true { 5; } else { 5.0; }"))
+	n, err := p.parseIf()
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+		return
+	}
+
+	_, err = InferTypes(n, nil, NewTypeWorlds(builtins))
+
+	if err == nil {
+		t.Fatalf("Expected error but got none.")
+		return
+	}
+}
+
+func TestInferTypeMatch(t *testing.T) {
+	intOrFloat, _ := NewUnionType([]Type{&PrimType{Type: "int"}, &PrimType{Type: "float"}})
+	intOrFloatOrBool, _ := NewUnionType([]Type{&PrimType{Type: "int"}, &PrimType{Type: "float"}, &PrimType{Type: "bool"}})
+
+	checkInferedTypeMatch("match { int { square.i; } float { toint; } }",
+		[]Type{intOrFloat}, []Type{&PrimType{Type: "int"}}, t)
+	checkInferedTypeMatch("match { {int float} { isint; } bool { } }",
+		[]Type{intOrFloatOrBool}, []Type{&PrimType{Type: "bool"}}, t)
+	checkInferedTypeMatch("match { int { } }",
+		[]Type{&PrimType{Type: "int"}}, []Type{&PrimType{Type: "int"}}, t)
+
+	// Missing case.
+	mustErrorInferedTypeMatch("match { int { } }", []Type{intOrFloat}, t)
+	// Not a member.
+	mustErrorInferedTypeMatch("match { int { } float { toint; } bool { } }", []Type{intOrFloat}, t)
+	// Duplicate case.
+	mustErrorInferedTypeMatch("match { int { } {int float} { toint; } }", []Type{intOrFloat}, t)
+	// Cases leaving different types.
+	mustErrorInferedTypeMatch("match { int { } float { } }", []Type{intOrFloat}, t)
+	// Narrowed type is wrong for square.i.
+	mustErrorInferedTypeMatch("match { int { } float { square.i; } }", []Type{intOrFloat}, t)
+	// Empty stack.
+	mustErrorInferedTypeMatch("match { int { } }", []Type{}, t)
+}
+
+// matchTypeWorld contains some functions to consume the narrowed
+// values in the tests for match.
+var matchTypeWorld TypeWorld = TypeWorld{
+	"toint": &FuncType{
+		ArgTypes: []Type{&PrimType{Type: "float"}},
+		RetTypes: []Type{&PrimType{Type: "int"}},
+	},
+	"isint": &FuncType{
+		ArgTypes: []Type{&UnionType{Types: []Type{&PrimType{Type: "float"}, &PrimType{Type: "int"}}}},
+		RetTypes: []Type{&PrimType{Type: "bool"}},
+	},
+}
+
+func checkInferedTypeMatch(code string, stack []Type, exp []Type, t *testing.T) {
+	p := NewParser(NewTokenizerString(code))
+	n, err := p.parseMatch()
+
+	if err != nil {
+		t.Fatalf("Unexpected error for %s: %s", code, err.Error())
+		return
+	}
+
+	types, err := InferTypes(n, stack, NewTypeWorlds(builtins, matchTypeWorld))
+
+	if err != nil {
+		t.Fatalf("Unexpected error for %s: %s", code, err.Error())
+		return
+	}
+
+	if !TypesEqual(types, exp) {
+		t.Fatalf("Expected types %s but got %s for %s.", exp, types, code)
+		return
+	}
+}
+
+func mustErrorInferedTypeMatch(code string, stack []Type, t *testing.T) {
+	p := NewParser(NewTokenizerString(code))
+	n, err := p.parseMatch()
+
+	if err != nil {
+		t.Fatalf("Unexpected error for %s: %s", code, err.Error())
+		return
+	}
+
+	types, err := InferTypes(n, stack, NewTypeWorlds(builtins, matchTypeWorld))
+
+	if err == nil {
+		t.Fatalf("Expected error but got none for: %s. {%s}", code, types)
+		return
+	}
+}
+
+func mustErrorInferedTypeIf(code string, wanted, got Type, t *testing.T) {
+	p := NewParser(NewTokenizerString(code))
+	n, err := p.parseIf()
+
+	if err != nil {
+		t.Fatalf("Unexpected error for %s: %s", code, err.Error())
+		return
+	}
+
+	checkTypeError(n, code, wanted, got, t)
+}
+
+func checkInferedTypeIf(code string, exp []Type, t *testing.T) {
+	p := NewParser(NewTokenizerString(code))
+	n, err := p.parseIf()
+
+	if err != nil {
+		t.Fatalf("Unexpected error for %s: %s", code, err.Error())
+		return
+	}
+
+	types, err := InferTypes(n, nil, NewTypeWorlds(builtins))
+
+	if err != nil {
+		t.Fatalf("Unexpected error for %s: %s", code, err.Error())
+		return
+	}
+
+	if !TypesEqual(types, exp) {
+		t.Fatalf("Expected types %s but got %s for %s.", exp, types, code)
+		return
+	}
+}
+
 func mustErrorInferedTypeExp(code string, wanted, got Type, t *testing.T) {
 	p := NewParser(NewTokenizerString(code))
 	n, err := p.parseExp()
@@ -20,6 +324,10 @@ func mustErrorInferedTypeExp(code string, wanted, got Type, t *testing.T) {
 		return
 	}
 
+	checkTypeError(n, code, wanted, got, t)
+}
+
+func checkTypeError(n Node, code string, wanted, got Type, t *testing.T) {
 	typ, err := InferTypes(n, nil, NewTypeWorlds(builtins))
 
 	if err == nil {
@@ -66,3 +374,244 @@ func checkInferedTypeExp(code string, exp []Type, t *testing.T) {
 		return
 	}
 }
+
+func TestTypeCheckErrorOrder(t *testing.T) {
+	var buf strings.Builder
+
+	for i := 0; i < 64; i++ {
+		fmt.Fprintf(&buf, "func f%s [] [int] { %d.0; }\n", benchmarkName(i, 3), i)
+	}
+
+	var first string
+
+	for i := 0; i < 20; i++ {
+		_, err := loadTestModules(map[string]string{"a": "func ok [] [int] { 1; }", "b": buf.String()})
+
+		if err == nil {
+			t.Fatalf("Expected an error but got none.")
+		}
+
+		if !strings.Contains(err.Error(), "`faaa`") {
+			t.Fatalf("Expected the error of the first function but got: %s", err.Error())
+		}
+
+		if first == "" {
+			first = err.Error()
+		} else if err.Error() != first {
+			t.Fatalf("Errors differ between runs: %q and %q", first, err.Error())
+		}
+	}
+}
+
+// benchmarkName spells i with at least width letters because names
+// can't contain digits.
+func benchmarkName(i int, width int) string {
+	name := ""
+
+	for ; i > 0 || width > 0; i, width = i/10, width-1 {
+		name = string(rune('a'+i%10)) + name
+	}
+
+	return name
+}
+
+// benchmarkCode returns code with n functions with non trivial bodies.
+// The names of the functions start with prefix.
+func benchmarkCode(module string, prefix string, n int) string {
+	var buf strings.Builder
+
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&buf, "func %s%s [(a int) (v {int bool list<int>})] [int] {\n", prefix, benchmarkName(i, 1))
+
+		buf.WriteString("\ta;\n")
+
+		for j := 0; j < 20; j++ {
+			fmt.Fprintf(&buf, "\ta square.i %d div.i div.i;\n", j+1)
+			buf.WriteString("\tv;\n\tmatch {\n\t\tint { square.i; }\n\t\tbool { if not { 1; } else { 0; } }\n\t\tlist<int> { len; }\n\t}\n")
+			buf.WriteString("\tdiv.i;\n")
+		}
+
+		if i > 0 {
+			fmt.Fprintf(&buf, "\ta v %s:%s%s div.i;\n", module, prefix, benchmarkName(i-1, 1))
+		}
+
+		buf.WriteString("}\n")
+	}
+
+	return buf.String()
+}
+
+func BenchmarkTypeCheck(b *testing.B) {
+	modules, err := loadTestModules(map[string]string{"bench": benchmarkCode("bench", "f", 200)})
+
+	if err != nil {
+		b.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	for _, workers := range []int{1, 0} {
+		name := "sequential"
+
+		if workers == 0 {
+			name = "parallel"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			defer func(old int) { maxWorkers = old }(maxWorkers)
+			maxWorkers = workers
+
+			for i := 0; i < b.N; i++ {
+				err := TypeCheck(modules)
+
+				if err != nil {
+					b.Fatalf("Unexpected error: %s", err.Error())
+				}
+			}
+		})
+	}
+}
+
+func TestTypeCheckMapKeys(t *testing.T) {
+	decls := "type point { x: int y: int }\n"
+
+	valid := []string{
+		"func f [(m map<int point>)] [] { }",
+		"func f [] [int] { map<string map<bool float>>[] len; }",
+		"type pair { m: map<float int> }",
+	}
+
+	for _, code := range valid {
+		_, err := loadTestModules(map[string]string{"m": decls + code})
+
+		if err != nil {
+			t.Fatalf("Unexpected error for %s: %s", code, err.Error())
+		}
+	}
+
+	invalid := []string{
+		"func f [(m map<point int>)] [] { }",
+		"func f [(m map<m:point int>)] [] { }",
+		"func f [(m map<error int>)] [] { }",
+		"func f [(m map<list<int> int>)] [] { }",
+		"func f [] [list<map<point int>>] { list<map<point int>>[]; }",
+		"func f [] [int] { map<point int>[] len; }",
+		"func f [(v {int map<error int>})] [] { }",
+		"type pair { m: map<point int> }",
+		"const m map<list<int> int>[]",
+		`func f [] [] {
+	map<point int>[] 1 1 m:point 1 put;
+	2 2 m:point 2 put;
+	'm:drop each;
+}
+
+func drop [(p point) (n int)] [] {
+}`,
+	}
+
+	for _, code := range invalid {
+		_, err := loadTestModules(map[string]string{"m": decls + code})
+
+		if err == nil || !strings.Contains(err.Error(), "Keys of maps must be of type `int`, `float`, `bool` or `string`") {
+			t.Fatalf("Expected an error for %s but got: %v", code, err)
+		}
+	}
+}
+
+// Type errors in expressions starting with a verb or a literal at the
+// end of a line are reported on that line and not on the next one.
+func TestTypeCheckErrorLine(t *testing.T) {
+	codes := []string{
+		"func half [] [float] { 0.5; }\n\nfunc f [] [int] {\n\tm:half\n\tsquare.i;\n}",
+		"func half [] [float] { 0.5; }\n\nfunc f [] [int] {\n\t1.5\n\tsquare.i;\n}",
+	}
+
+	for _, code := range codes {
+		_, err := loadTestModules(map[string]string{"m": code})
+
+		te, ok := err.(*TypeError)
+
+		if !ok || te.Token.Pos.LineNumber != 4 {
+			t.Fatalf("Expected a type error on line 4 for %q but got: %v", code, err)
+		}
+	}
+}
+
+func TestTypeCheckFail(t *testing.T) {
+	valid := []string{
+		"func f [] [int] { \"boom\" fail; }",
+		"func f [(a int)] [int list<string>] { 1 \"boom\" fail; }",
+		"func f [(a int) (b bool)] [int] { if b { \"zero\" fail; } else { a; } }",
+		"func f [(a int) (b bool)] [int] { if b { a; } else { \"zero\" fail; } }",
+		"func f [(a int) (b bool)] [int] { if b { \"zero\" fail; } else { \"other\" fail; } }",
+		"func f [(a {int float})] [int] { a; match { float { \"float\" fail; } int { } } }",
+		"func f [(a {int float bool})] [int] { a; match { int { } float { \"float\" fail; } bool { \"bool\" fail; } } }",
+	}
+
+	for _, code := range valid {
+		_, err := loadTestModules(map[string]string{"m": code})
+
+		if err != nil {
+			t.Fatalf("Unexpected error for %s: %s", code, err.Error())
+		}
+	}
+
+	invalid := map[string]string{
+		"func f [] [int] { \"boom\" fail 1; }":                                            "Unreachable code after `fail`",
+		"func f [] [int] { \"boom\" fail;\n1; }":                                          "Unreachable code after `fail`",
+		"func f [] [int] { if \"boom\" fail { 1; } else { 2; } }":                         "Unreachable code after `fail`",
+		"func f [] [int] { 1 fail; }":                                                     "Type error",
+		"func f [(a int) (b bool)] [int] { if b { \"zero\" fail; } else { 1.0; } }":       "Type error",
+		"func f [(a {int float})] [int] { a; match { int { \"int\" fail; } float { } } }": "Type error",
+	}
+
+	for code, msg := range invalid {
+		_, err := loadTestModules(map[string]string{"m": code})
+
+		if err == nil || !strings.Contains(err.Error(), msg) {
+			t.Fatalf("Expected an error containing %q for %s but got: %v", msg, code, err)
+		}
+	}
+}
+
+func TestTypeCheckVisibility(t *testing.T) {
+	geo := `
+pub type point { x: int y: int }
+type secret { v: int }
+
+pub func origin [] [point] {
+	geo:helper geo:helper geo:point;
+}
+
+func helper [] [int] {
+	0 geo:secret geo:secret.v;
+}
+`
+	codes := []string{
+		"func f [] [int] { geo:origin geo:point.x; }",
+		"func f [] [int] { 1 2 geo:point 0 geo:point.y.set geo:point.y; }",
+		"func f [] [int] { geo:helper; }",
+		"func f [] [int] { 'geo:helper; }",
+		"func f [] [int] { 1 geo:secret geo:secret.v; }",
+		"func f [] [int] { if true { geo:helper; } else { 0; } }",
+	}
+
+	for i, code := range codes {
+		_, err := loadTestModules(map[string]string{"geo": geo, "app": code})
+
+		if i < 2 && err != nil {
+			t.Fatalf("Unexpected error for %s: %s", code, err.Error())
+		}
+
+		if i >= 2 && (err == nil || !strings.Contains(err.Error(), "private")) {
+			t.Fatalf("Expected a visibility error for %s but got: %v", code, err)
+		}
+	}
+
+	// `pub` on a type only affects its functions. Private types can
+	// still be named by other modules.
+	code := "func f [(s geo:secret)] [geo:secret] { s; }"
+	_, err := loadTestModules(map[string]string{"geo": geo, "app": code})
+
+	if err != nil {
+		t.Fatalf("Unexpected error for %s: %s", code, err.Error())
+	}
+}
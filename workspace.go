@@ -0,0 +1,431 @@
+package gocat
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Workspace loads and type checks a set of modules incrementally. Parsed
+// files are cached by their content hash and only functions whose code
+// changed or that call functions whose types changed are type checked
+// again.
+type Workspace struct {
+	fsys fs.FS
+	dirs []string
+
+	host *Host
+	caps Capabilities
+
+	// files maps the paths of files to their cached parse results.
+	files map[string]*cachedFile
+
+	// types are the types and visibility of all functions as of the
+	// last check.
+	types map[string]funcSig
+
+	// checked maps the functions that passed the type checker to the
+	// code they passed with.
+	checked map[string]*FuncNode
+
+	modules  map[string]*Module
+	deps     DepGraph
+	rechecks []string
+}
+
+// funcSig is what callers of a function rely on.
+type funcSig struct {
+	typ *FuncType
+	pub bool
+}
+
+type cachedFile struct {
+	hash [sha256.Size]byte
+	root *RootNode
+}
+
+// DepGraph maps the fully qualified names of functions to the sorted
+// fully qualified names of the functions they call or quote.
+type DepGraph map[string][]string
+
+// Dependents returns the sorted names of the functions calling or
+// quoting the function fqname.
+func (g DepGraph) Dependents(fqname string) []string {
+	var dependents []string
+
+	for caller, callees := range g {
+		i := sort.SearchStrings(callees, fqname)
+
+		if i < len(callees) && callees[i] == fqname {
+			dependents = append(dependents, caller)
+		}
+	}
+
+	sort.Strings(dependents)
+	return dependents
+}
+
+func NewWorkspace(fsys fs.FS) *Workspace {
+	return &Workspace{
+		fsys:    fsys,
+		files:   make(map[string]*cachedFile),
+		types:   make(map[string]funcSig),
+		checked: make(map[string]*FuncNode),
+	}
+}
+
+// AddModule adds the module in the directory dir of the file system of
+// the workspace.
+func (w *Workspace) AddModule(dir string) error {
+	dir = path.Clean(dir)
+
+	for _, other := range w.dirs {
+		if other == dir {
+			return fmt.Errorf("Duplicate module directory `%s`.", dir)
+		}
+	}
+
+	w.dirs = append(w.dirs, dir)
+	return nil
+}
+
+// SetHost sets the host functions available to the modules. All
+// functions are type checked again by the next Check.
+func (w *Workspace) SetHost(host *Host, caps Capabilities) {
+	w.host = host
+	w.caps = caps
+	w.checked = make(map[string]*FuncNode)
+}
+
+// Check loads the modules of the workspace and type checks them. Files
+// that did not change since the last check are not parsed again.
+func (w *Workspace) Check() (map[string]*Module, error) {
+	w.rechecks = nil
+
+	loaded, err := w.loadFiles()
+
+	if err != nil {
+		return nil, err
+	}
+
+	modules := make(map[string]*Module)
+
+	for _, lm := range loaded {
+		module := &Module{
+			Name:     path.Base(lm.dir),
+			Path:     lm.dir,
+			Funcs:    make(map[string]*Func),
+			Types:    make(map[string]*RecordType),
+			Manifest: lm.manifest,
+		}
+
+		if lm.manifest != nil {
+			module.Name = lm.manifest.Name
+		}
+
+		if modules[module.Name] != nil {
+			return nil, fmt.Errorf("Duplicate module `%s` in `%s` and `%s`.",
+				module.Name, modules[module.Name].Path, lm.dir)
+		}
+
+		for _, fpath := range lm.files {
+			err = module.addRoot(w.files[fpath].root)
+
+			if err != nil {
+				return nil, &LoadModuleError{
+					FilePath:   fpath,
+					ModulePath: lm.dir,
+					Msg:        err.Error(),
+				}
+			}
+		}
+
+		err = module.applyExports()
+
+		if err != nil {
+			return nil, &LoadModuleError{
+				FilePath:   path.Join(lm.dir, ManifestFile),
+				ModulePath: lm.dir,
+				Msg:        err.Error(),
+			}
+		}
+
+		resolveModule(module, module.lookupLocalType)
+		modules[module.Name] = module
+	}
+
+	err = checkRequires(modules)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkImports(modules)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = resolveModules(modules)
+
+	if err != nil {
+		return nil, err
+	}
+
+	deps := make(DepGraph)
+	types := make(map[string]funcSig)
+
+	for name, module := range modules {
+		for fname, fn := range module.Funcs {
+			fqname := name + ":" + fname
+			types[fqname] = funcSig{fn.Type, fn.Pub}
+
+			if fn.FuncNode != nil {
+				deps[fqname] = funcDeps(module, fn.FuncNode)
+			}
+		}
+	}
+
+	w.invalidate(types, deps)
+	w.types = types
+	w.deps = deps
+
+	for name, module := range modules {
+		for fname, fn := range module.Funcs {
+			fqname := name + ":" + fname
+
+			if fn.FuncNode != nil && w.checked[fqname] != fn.FuncNode {
+				w.rechecks = append(w.rechecks, fqname)
+			}
+		}
+	}
+
+	sort.Strings(w.rechecks)
+
+	err = typeCheckModules(modules, w.host, w.caps, func(fqname string) bool {
+		return w.checked[fqname] != nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, fqname := range w.rechecks {
+		i := strings.LastIndex(fqname, ":")
+		w.checked[fqname] = modules[fqname[:i]].Funcs[fqname[i+1:]].FuncNode
+	}
+
+	w.modules = modules
+	return modules, nil
+}
+
+// loadedDir is a module directory as loaded by loadFiles.
+type loadedDir struct {
+	dir      string
+	manifest *Manifest
+	files    []string
+}
+
+// loadFiles loads the manifests of the modules and parses the files that
+// changed since the last check.
+func (w *Workspace) loadFiles() ([]*loadedDir, error) {
+	var loaded []*loadedDir
+	seen := make(map[string]bool)
+
+	var changed []string
+	var sources [][]byte
+	var hashes [][sha256.Size]byte
+
+	for _, dir := range w.dirs {
+		fi, err := fs.Stat(w.fsys, dir)
+
+		if err == nil && !fi.IsDir() {
+			err = fmt.Errorf("Not a directory.")
+		}
+
+		if err != nil {
+			return nil, &LoadModuleError{
+				ModulePath: dir,
+				FilePath:   "<n/a>",
+				Msg:        err.Error(),
+			}
+		}
+
+		manifest, err := loadManifest(w.fsys, dir)
+
+		if err != nil {
+			return nil, &LoadModuleError{
+				ModulePath: dir,
+				FilePath:   path.Join(dir, ManifestFile),
+				Msg:        err.Error(),
+			}
+		}
+
+		lm := &loadedDir{
+			dir:      dir,
+			manifest: manifest,
+		}
+
+		loaded = append(loaded, lm)
+
+		matches, err := fs.Glob(w.fsys, path.Join(dir, "*.gct"))
+
+		if err != nil {
+			return nil, &LoadModuleError{
+				ModulePath: dir,
+				FilePath:   "<n/a>",
+				Msg:        err.Error(),
+			}
+		}
+
+		for _, fpath := range withoutTestFiles(matches) {
+			src, err := fs.ReadFile(w.fsys, fpath)
+
+			if err != nil {
+				return nil, &LoadModuleError{
+					FilePath:   fpath,
+					ModulePath: dir,
+					Msg:        err.Error(),
+				}
+			}
+
+			seen[fpath] = true
+			lm.files = append(lm.files, fpath)
+
+			hash := sha256.Sum256(src)
+			cached := w.files[fpath]
+
+			if cached == nil || cached.hash != hash {
+				changed = append(changed, fpath)
+				sources = append(sources, src)
+				hashes = append(hashes, hash)
+			}
+		}
+	}
+
+	// Files that no longer exist are evicted from the cache.
+	for fpath := range w.files {
+		if !seen[fpath] {
+			delete(w.files, fpath)
+		}
+	}
+
+	parsed := make([]*RootNode, len(changed))
+
+	err := forEachParallel(len(changed), func(i int) error {
+		p := NewParser(NewTokenizerReader(bytes.NewReader(sources[i]), changed[i]))
+
+		root, err := p.Root()
+
+		if err != nil {
+			return &LoadModuleError{
+				FilePath:   changed[i],
+				ModulePath: path.Dir(changed[i]),
+				Msg:        err.Error(),
+			}
+		}
+
+		parsed[i] = root
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	for i, fpath := range changed {
+		w.files[fpath] = &cachedFile{
+			hash: hashes[i],
+			root: parsed[i],
+		}
+	}
+
+	return loaded, nil
+}
+
+// invalidate forgets that functions passed the type checker if they
+// call functions whose types or visibility differ from the last check.
+func (w *Workspace) invalidate(types map[string]funcSig, deps DepGraph) {
+	for fqname, old := range w.types {
+		sig, ok := types[fqname]
+
+		if ok && sig.pub == old.pub && TypeCmp(old.typ, sig.typ) == 0 {
+			continue
+		}
+
+		for _, dependent := range deps.Dependents(fqname) {
+			delete(w.checked, dependent)
+		}
+	}
+
+	// New functions may make unqualified references of the functions
+	// of their module ambiguous so all of them are checked again.
+	for fqname := range types {
+		if _, ok := w.types[fqname]; !ok {
+			prefix := fqname[:strings.LastIndex(fqname, ":")+1]
+
+			for checked := range w.checked {
+				if strings.HasPrefix(checked, prefix) {
+					delete(w.checked, checked)
+				}
+			}
+
+			for _, dependent := range deps.Dependents(fqname) {
+				delete(w.checked, dependent)
+			}
+		}
+	}
+
+	for fqname := range w.checked {
+		if _, ok := types[fqname]; !ok {
+			delete(w.checked, fqname)
+		}
+	}
+}
+
+// Modules returns the modules of the last successful check.
+func (w *Workspace) Modules() map[string]*Module {
+	return w.modules
+}
+
+// Deps returns the dependency graph of the last check.
+func (w *Workspace) Deps() DepGraph {
+	return w.deps
+}
+
+// Rechecked returns the sorted names of the functions type checked by
+// the last check.
+func (w *Workspace) Rechecked() []string {
+	return w.rechecks
+}
+
+// funcDeps returns the sorted fully qualified names of the functions
+// called or quoted by the function fn of the module.
+func funcDeps(module *Module, fn *FuncNode) []string {
+	set := make(map[string]bool)
+
+	walkRefs(fn.Body, func(name string, tk *Token) error {
+		if strings.ContainsRune(name, ':') {
+			set[name] = true
+			return nil
+		}
+
+		for _, arg := range fn.Args {
+			if arg.Name == name {
+				return nil
+			}
+		}
+
+		if module.Funcs[name] != nil {
+			set[module.Name+":"+name] = true
+		}
+
+		return nil
+	})
+
+	return sortedNames(set)
+}
@@ -0,0 +1,209 @@
+package gocat
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestWorkspace(t *testing.T) {
+	fsys := fstest.MapFS{
+		"geo/point.gct": &fstest.MapFile{
+			Data: []byte("pub type point { x : int y : int }\n"),
+		},
+		"geo/funcs.gct": &fstest.MapFile{
+			Data: []byte("pub func origin [] [point] { 0 0 geo:point; }\nfunc two [] [int] { 2; }\n"),
+		},
+		"app/main.gct": &fstest.MapFile{
+			Data: []byte("func x [] [int] { geo:origin geo:point.x; }\nfunc y [] [int] { 1; }\n"),
+		},
+	}
+
+	w := NewWorkspace(fsys)
+
+	if w.AddModule("geo") != nil || w.AddModule("app") != nil {
+		t.Fatalf("Unexpected error adding modules.")
+	}
+
+	if w.AddModule("geo/") == nil {
+		t.Fatalf("Expected an error adding a duplicate module.")
+	}
+
+	checkWorkspace(w, []string{"app:x", "app:y", "geo:origin", "geo:two"}, t)
+
+	if !reflect.DeepEqual(w.Deps()["app:x"], []string{"geo:origin", "geo:point.x"}) {
+		t.Fatalf("Unexpected dependencies: %v", w.Deps()["app:x"])
+	}
+
+	if !reflect.DeepEqual(w.Deps().Dependents("geo:origin"), []string{"app:x"}) {
+		t.Fatalf("Unexpected dependents: %v", w.Deps().Dependents("geo:origin"))
+	}
+
+	// Nothing changed.
+	checkWorkspace(w, nil, t)
+
+	// The body changed but the types did not.
+	fsys["geo/funcs.gct"] = &fstest.MapFile{
+		Data: []byte("pub func origin [] [point] { 1 1 geo:point; }\nfunc two [] [int] { 2; }\n"),
+	}
+
+	checkWorkspace(w, []string{"geo:origin", "geo:two"}, t)
+
+	// The type of a field changed which changes the types of the getter
+	// and thus requires checking its callers.
+	fsys["geo/point.gct"] = &fstest.MapFile{
+		Data: []byte("pub type point { x : float y : int }\n"),
+	}
+
+	mustErrorWorkspace(w, t)
+
+	// Failed functions are checked again.
+	fsys["app/main.gct"] = &fstest.MapFile{
+		Data: []byte("func x [] [float] { geo:origin geo:point.x; }\nfunc y [] [int] { 1; }\n"),
+	}
+
+	mustErrorWorkspace(w, t)
+
+	fsys["geo/funcs.gct"] = &fstest.MapFile{
+		Data: []byte("pub func origin [] [point] { 1.0 1 geo:point; }\nfunc two [] [int] { 2; }\n"),
+	}
+
+	checkWorkspace(w, []string{"app:x", "app:y", "geo:origin", "geo:two"}, t)
+
+	// Removing a record referenced by another module is an error.
+	fsys["app/main.gct"] = &fstest.MapFile{
+		Data: []byte("func x [(p geo:point)] [] { }\n"),
+	}
+
+	checkWorkspace(w, []string{"app:x"}, t)
+
+	delete(fsys, "geo/point.gct")
+	fsys["geo/funcs.gct"] = &fstest.MapFile{
+		Data: []byte("pub func two [] [int] { 2; }\n"),
+	}
+
+	mustErrorWorkspace(w, t)
+
+	fsys["app/main.gct"] = &fstest.MapFile{
+		Data: []byte("func x [] [int] { geo:two; }\n"),
+	}
+
+	// geo:two changed with the last failed check.
+	checkWorkspace(w, []string{"app:x", "geo:two"}, t)
+
+	// Parse errors are reported.
+	fsys["app/main.gct"] = &fstest.MapFile{
+		Data: []byte("func x [] [int] {\n"),
+	}
+
+	mustErrorWorkspace(w, t)
+}
+
+func checkWorkspace(w *Workspace, rechecked []string, t *testing.T) {
+	modules, err := w.Check()
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	if !reflect.DeepEqual(w.Modules(), modules) {
+		t.Fatalf("Modules don't match the modules of the last check.")
+	}
+
+	if !reflect.DeepEqual(w.Rechecked(), rechecked) {
+		t.Fatalf("Expected %v to be checked but got %v.", rechecked, w.Rechecked())
+	}
+}
+
+func mustErrorWorkspace(w *Workspace, t *testing.T) {
+	_, err := w.Check()
+
+	if err == nil {
+		t.Fatalf("Expected an error but got none.")
+	}
+}
+
+func TestWorkspaceManifest(t *testing.T) {
+	fsys := fstest.MapFS{
+		"lib/gocat.mod": &fstest.MapFile{
+			Data: []byte("module geo\nversion 1.2.0\nexport two\n"),
+		},
+		"lib/funcs.gct": &fstest.MapFile{
+			Data: []byte("pub func two [] [int] { 2; }\n"),
+		},
+		"app/gocat.mod": &fstest.MapFile{
+			Data: []byte("module app\nrequire geo 1.1.0\n"),
+		},
+		"app/main.gct": &fstest.MapFile{
+			Data: []byte("func x [] [int] { geo:two; }\n"),
+		},
+	}
+
+	w := NewWorkspace(fsys)
+	w.AddModule("lib")
+	w.AddModule("app")
+
+	checkWorkspace(w, []string{"app:x", "geo:two"}, t)
+
+	fsys["app/gocat.mod"] = &fstest.MapFile{
+		Data: []byte("module app\nrequire geo 1.3.0\n"),
+	}
+
+	mustErrorWorkspace(w, t)
+
+	fsys["app/gocat.mod"] = &fstest.MapFile{
+		Data: []byte("module geo\n"),
+	}
+
+	mustErrorWorkspace(w, t)
+}
+
+func TestWorkspaceVisibility(t *testing.T) {
+	fsys := fstest.MapFS{
+		"lib/funcs.gct": &fstest.MapFile{
+			Data: []byte("pub func f [] [int] { 2; }\nfunc g [] [int] { 3; }\n"),
+		},
+		"lib/gocat.mod": &fstest.MapFile{
+			Data: []byte("module lib\nexport g\n"),
+		},
+		"app/main.gct": &fstest.MapFile{
+			Data: []byte("func x [] [int] { lib:f; }\nfunc y [] [int] { lib:g; }\n"),
+		},
+	}
+
+	w := NewWorkspace(fsys)
+	w.AddModule("lib")
+	w.AddModule("app")
+
+	checkWorkspace(w, []string{"app:x", "app:y", "lib:f", "lib:g"}, t)
+
+	// Making a called function private changes neither its type nor
+	// its callers.
+	fsys["lib/funcs.gct"] = &fstest.MapFile{
+		Data: []byte("func f [] [int] { 2; }\nfunc g [] [int] { 3; }\n"),
+	}
+
+	_, err := w.Check()
+
+	if err == nil || !strings.Contains(err.Error(), "Function `lib:f` referenced") {
+		t.Fatalf("Expected a visibility error but got: %v", err)
+	}
+
+	fsys["lib/funcs.gct"] = &fstest.MapFile{
+		Data: []byte("pub func f [] [int] { 2; }\nfunc g [] [int] { 3; }\n"),
+	}
+
+	checkWorkspace(w, []string{"app:x", "lib:f", "lib:g"}, t)
+
+	// The same goes for functions no longer exported by the manifest.
+	fsys["lib/gocat.mod"] = &fstest.MapFile{
+		Data: []byte("module lib\n"),
+	}
+
+	_, err = w.Check()
+
+	if err == nil || !strings.Contains(err.Error(), "Function `lib:g` referenced") {
+		t.Fatalf("Expected a visibility error but got: %v", err)
+	}
+}